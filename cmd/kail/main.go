@@ -6,19 +6,16 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
-	"strings"
 	"syscall"
 	"text/tabwriter"
 
 	logutil "github.com/boz/go-logutil"
 	logutil_logrus "github.com/boz/go-logutil/logrus"
 	"github.com/boz/kail"
-	"github.com/boz/kcache/nsname"
 	"github.com/boz/kcache/util"
 	"github.com/sirupsen/logrus"
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 	"k8s.io/client-go/rest"
@@ -41,12 +38,15 @@ var (
 	flagRs         = kingpin.Flag("rs", "replica set").PlaceHolder("NAME").Strings()
 	flagDs         = kingpin.Flag("ds", "daemonset").PlaceHolder("NAME").Strings()
 	flagDeployment = kingpin.Flag("deploy", "deployment").Short('d').PlaceHolder("NAME").Strings()
+	flagDC         = kingpin.Flag("dc", "openshift deployment config").PlaceHolder("NAME").Strings()
 	flagNode       = kingpin.Flag("node", "node").PlaceHolder("NAME").Strings()
 	flagIng        = kingpin.Flag("ing", "ingress").PlaceHolder("NAME").Strings()
 
 	flagContext = kingpin.Flag("context", "kubernetes context").PlaceHolder("CONTEXT-NAME").String()
 
-	flagContainers = kingpin.Flag("containers", "containers").Short('c').PlaceHolder("NAME").Strings()
+	flagContainers        = kingpin.Flag("containers", "containers").Short('c').PlaceHolder("NAME").Strings()
+	flagExcludeContainers = kingpin.Flag("exclude-containers", "containers to exclude").PlaceHolder("NAME").Strings()
+	flagInitContainers    = kingpin.Flag("init-containers", "include init container logs").Bool()
 
 	flagDryRun = kingpin.Flag("dry-run", "print matching pods and exit").
 			Default("false").
@@ -65,6 +65,19 @@ var (
 			Default("1s").
 			Duration()
 
+	flagTail = kingpin.Flag("tail", "Number of existing lines to display when a log is first opened; -1 for all.").
+			PlaceHolder("LINES").
+			Default("0").
+			Int64()
+
+	flagTimestamps = kingpin.Flag("timestamps", "Prefix each log line with its kubelet timestamp.").
+			Default("false").
+			Bool()
+
+	flagPrevious = kingpin.Flag("previous", "Display the previous logs of restarted containers.").
+			Default("false").
+			Bool()
+
 	flagGlogV = kingpin.Flag("glog-v", "glog -v value").
 			Default("0").
 			String()
@@ -106,15 +119,13 @@ func main() {
 
 	ds := createDS(ctx, cs, dsb)
 
-	filter := kail.NewContainerFilter(*flagContainers)
-
 	if *flagDryRun {
 
-		listPods(ds, filter)
+		listPods(ds)
 
 	} else {
 
-		streamLogs(createController(ctx, cs, rc, ds, filter))
+		streamLogs(createController(ctx, cs, rc, ds))
 
 	}
 
@@ -180,71 +191,50 @@ func createKubeClient() (kubernetes.Interface, *rest.Config) {
 }
 
 func createDSBuilder() kail.DSBuilder {
-	dsb := kail.NewDSBuilder()
-
-	if selectors := parseLabels("ignore", *flagIgnore); len(selectors) > 0 {
-		dsb = dsb.WithIgnore(selectors...)
-	}
-
-	if selectors := parseLabels("selector", *flagLabel); len(selectors) > 0 {
-		dsb = dsb.WithSelectors(selectors...)
-	}
-
-	if ids := parseIds("pod", *flagPod); len(ids) > 0 {
-		dsb = dsb.WithPods(ids...)
-	}
-
-	if len(*flagNs) > 0 {
-		dsb = dsb.WithNamespace(*flagNs...)
-	}
-
-	if ids := parseIds("service", *flagSvc); len(ids) > 0 {
-		dsb = dsb.WithService(ids...)
-	}
-
-	if len(*flagNode) > 0 {
-		dsb = dsb.WithNode(*flagNode...)
-	}
-
-	if ids := parseIds("rc", *flagRc); len(ids) > 0 {
-		dsb = dsb.WithRC(ids...)
-	}
-
-	if ids := parseIds("rs", *flagRs); len(ids) > 0 {
-		dsb = dsb.WithRS(ids...)
-	}
-
-	if ids := parseIds("ds", *flagDs); len(ids) > 0 {
-		dsb = dsb.WithDS(ids...)
-	}
-
-	if ids := parseIds("deploy", *flagDeployment); len(ids) > 0 {
-		dsb = dsb.WithDeployment(ids...)
-	}
-
-	if ids := parseIds("ing", *flagIng); len(ids) > 0 {
-		dsb = dsb.WithIngress(ids...)
-	}
-
-	return dsb
+	dsb, err := kail.NewDSBuilderFromConfig(kail.Config{
+		Ignore:      *flagIgnore,
+		Selectors:   *flagLabel,
+		Pods:        *flagPod,
+		Namespaces:  *flagNs,
+		Services:    *flagSvc,
+		Nodes:       *flagNode,
+		RCs:         *flagRc,
+		RSs:         *flagRs,
+		DSs:         *flagDs,
+		Deployments: *flagDeployment,
+		DCs:         *flagDC,
+		Ingresses:   *flagIng,
+
+		// the builder requires watching every namespace to be explicit;
+		// the command has always done so when no namespace is given.
+		AllNamespaces: len(*flagNs) == 0,
+	})
+	kingpin.FatalIfError(err, "Invalid selection criteria")
+	return dsb.
+		WithSince(*flagSince).
+		WithTail(*flagTail).
+		WithTimestamps(*flagTimestamps).
+		WithPrevious(*flagPrevious).
+		WithContainerInclude(*flagContainers...).
+		WithContainerExclude(*flagExcludeContainers...).
+		WithInitContainers(*flagInitContainers)
 }
 
 func createDS(ctx context.Context, cs kubernetes.Interface, dsb kail.DSBuilder) kail.DS {
 	ds, err := dsb.Create(ctx, cs)
 	kingpin.FatalIfError(err, "Error creating datasource")
 
-	select {
-	case <-ds.Ready():
-	case <-ds.Done():
-		kingpin.Fatalf("Unable to initialize data source")
-	}
+	err = ds.ReadyContext(ctx)
+	kingpin.FatalIfError(err, "Unable to initialize data source")
 	return ds
 }
 
-func listPods(ds kail.DS, filter kail.ContainerFilter) {
+func listPods(ds kail.DS) {
 	pods, err := ds.Pods().Cache().List()
 	kingpin.FatalIfError(err, "Error fetching pods")
 
+	filter := ds.ContainerFilter()
+
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
 
 	fmt.Fprintln(w, "NAMESPACE\tNAME\tCONTAINER\tNODE")
@@ -260,9 +250,9 @@ func listPods(ds kail.DS, filter kail.ContainerFilter) {
 }
 
 func createController(
-	ctx context.Context, cs kubernetes.Interface, rc *rest.Config, ds kail.DS, filter kail.ContainerFilter) kail.Controller {
+	ctx context.Context, cs kubernetes.Interface, rc *rest.Config, ds kail.DS) kail.Controller {
 
-	controller, err := kail.NewController(ctx, cs, rc, ds.Pods(), filter, *flagSince)
+	controller, err := kail.NewDSController(ctx, cs, rc, ds)
 	kingpin.FatalIfError(err, "Error creating controller")
 
 	return controller
@@ -274,38 +264,13 @@ func streamLogs(controller kail.Controller) {
 
 	for {
 		select {
-		case ev := <-controller.Events():
+		case ev, ok := <-controller.Events():
+			if !ok {
+				return
+			}
 			writer.Print(ev)
 		case <-controller.Done():
 			return
 		}
 	}
 }
-
-func parseLabels(name string, vals []string) []labels.Selector {
-	var selectors []labels.Selector
-	for _, val := range vals {
-		selector, err := labels.Parse(val)
-		kingpin.FatalIfError(err, "invalid %v labels expression: '%v'", name, val)
-		selectors = append(selectors, selector)
-	}
-	return selectors
-}
-
-func parseIds(name string, vals []string) []nsname.NSName {
-	var ids []nsname.NSName
-
-	for _, val := range vals {
-		parts := strings.Split(val, "/")
-		switch len(parts) {
-		case 2:
-			ids = append(ids, nsname.New(parts[0], parts[1]))
-		case 1:
-			ids = append(ids, nsname.New("", parts[0]))
-		default:
-			kingpin.Fatalf("Invalid %v name: '%v'", name, val)
-		}
-	}
-
-	return ids
-}
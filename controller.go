@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 
@@ -20,7 +21,11 @@ const (
 )
 
 type Controller interface {
+	// Events delivers log lines from all streamed containers.  It is closed
+	// once the controller is done and every buffered line has been
+	// delivered, so ranging over it terminates on Close.
 	Events() <-chan Event
+
 	Close()
 	Done() <-chan struct{}
 }
@@ -33,10 +38,55 @@ func NewController(
 	filter ContainerFilter,
 	since time.Duration) (Controller, error) {
 
+	mconfig := monitorConfig{
+		since:   since,
+		clock:   clock.RealClock{},
+		streams: newStreamLimiter(0),
+	}
+
 	pods, err := pcontroller.Subscribe()
 	if err != nil {
 		return nil, err
 	}
+	return newController(ctx, cs, rc, pods, filter, mconfig, false, nullMetrics{}, 0)
+}
+
+// NewDSController streams the logs of the pods matched by ds, honoring the
+// datastore's log and container options.  It follows ds across Refresh and
+// Reconfigure.
+func NewDSController(
+	ctx context.Context,
+	cs kubernetes.Interface,
+	rc *rest.Config,
+	ds DS) (Controller, error) {
+
+	mconfig := monitorConfig{
+		since:      ds.Since(),
+		tail:       ds.Tail(),
+		timestamps: ds.Timestamps(),
+		backoff:    newReconnectBackoff(ds.ReconnectBackoff()),
+		clock:      ds.Clock(),
+		streams:    dsStreamLimiter(ds),
+	}
+
+	pods, err := subscribeDSPods(ds)
+	if err != nil {
+		return nil, err
+	}
+	return newController(ctx, cs, rc, pods, ds.ContainerFilter(), mconfig,
+		ds.Previous(), ds.Metrics(), ds.OrderedOutput())
+}
+
+func newController(
+	ctx context.Context,
+	cs kubernetes.Interface,
+	rc *rest.Config,
+	pods pod.Subscription,
+	filter ContainerFilter,
+	mconfig monitorConfig,
+	previous bool,
+	metrics Metrics,
+	ordered time.Duration) (Controller, error) {
 
 	initial, err := pods.Cache().List()
 	if err != nil {
@@ -55,15 +105,26 @@ func NewController(
 		rc:        rc,
 		pods:      pods,
 		filter:    filter,
-		mconfig:   monitorConfig{since: since},
+		mconfig:   mconfig,
+		previous:  previous,
+		metrics:   metrics,
+		restarts:  newRestartTracker(),
+		initDone:  make(map[eventSource]bool),
 		eventch:   make(chan Event, eventBufsiz),
-		monitorch: make(chan eventSource),
+		monitorch: make(chan monitorExit),
 		monitors:  make(map[nsname.NSName]podMonitors),
 		log:       log,
 		ctx:       ctx,
 		lc:        lc,
 	}
 
+	c.outch = c.eventch
+	if ordered > 0 {
+		outch := make(chan Event, eventBufsiz)
+		c.outch = outch
+		go orderEvents(mconfig.clock, ordered, c.eventch, outch)
+	}
+
 	go c.run(initial)
 
 	return c, nil
@@ -76,21 +137,35 @@ type controller struct {
 	filter ContainerFilter
 
 	eventch   chan Event
-	monitorch chan eventSource
+	outch     chan Event
+	monitorch chan monitorExit
 
 	monitors monitors
 	mconfig  monitorConfig
 
+	previous bool
+	restarts *restartTracker
+	metrics  Metrics
+
+	// initDone holds init containers whose logs have been read to
+	// completion; they are not streamed again.
+	initDone map[eventSource]bool
+
 	log logutil.Log
 	ctx context.Context
 	lc  lifecycle.Lifecycle
 }
 
+type monitorExit struct {
+	source  eventSource
+	monitor monitor
+}
+
 type podMonitors map[eventSource]monitor
 type monitors map[nsname.NSName]podMonitors
 
 func (c *controller) Events() <-chan Event {
-	return c.eventch
+	return c.outch
 }
 
 func (c *controller) Done() <-chan struct{} {
@@ -147,11 +222,19 @@ func (c *controller) run(initial []*v1.Pod) {
 				c.handlePodEvent(ev)
 			}
 
-		case source := <-c.monitorch:
+		case exit := <-c.monitorch:
+			source := exit.source
 			if pms, ok := c.monitors[source.id]; ok {
-				if _, ok := pms[source]; ok {
+				if pm, ok := pms[source]; ok {
+					if pm != exit.monitor {
+						c.log.Debugf("replaced source %v exited", source)
+						break
+					}
 					c.log.Debugf("removing source %v", source)
 					delete(pms, source)
+					if source.init {
+						c.initDone[source] = true
+					}
 					if len(pms) == 0 {
 						c.log.Debugf("removing pod %v", source.id)
 						delete(c.monitors, source.id)
@@ -165,6 +248,9 @@ func (c *controller) run(initial []*v1.Pod) {
 
 	c.pods.Close()
 	<-c.pods.Done()
+
+	// every monitor has exited, so nothing else writes to eventch.
+	close(c.eventch)
 }
 
 func (c *controller) handlePodEvent(ev pod.Event) {
@@ -180,6 +266,12 @@ func (c *controller) handlePodEvent(ev pod.Event) {
 				pm.Shutdown()
 			}
 		}
+		c.restarts.remove(id)
+		for source := range c.initDone {
+			if source.id == id {
+				delete(c.initDone, source)
+			}
+		}
 		return
 	}
 
@@ -189,6 +281,8 @@ func (c *controller) handlePodEvent(ev pod.Event) {
 func (c *controller) ensureMonitorsForPod(pod *v1.Pod) {
 	id, sources := sourcesForPod(c.filter, pod)
 
+	c.restarts.update(pod)
+
 	c.log.Debugf("pod %v/%v: %v containers ready",
 		pod.GetNamespace(), pod.GetName(), len(sources))
 
@@ -211,9 +305,17 @@ func (c *controller) ensureMonitorsForPod(pod *v1.Pod) {
 	}
 
 	for source, _ := range sources {
-		if _, ok := pms[source]; ok {
+		if c.initDone[source] {
 			continue
 		}
+		if pm, ok := pms[source]; ok {
+			if !c.restarts.restarted(source) {
+				continue
+			}
+			c.log.Debugf("reconnecting restarted source %v", source)
+			c.metrics.Reconnect()
+			pm.Shutdown()
+		}
 		pms[source] = c.createMonitor(source)
 	}
 
@@ -223,7 +325,15 @@ func (c *controller) ensureMonitorsForPod(pod *v1.Pod) {
 func (c *controller) createMonitor(source eventSource) monitor {
 	defer c.log.Un(c.log.Trace("createMonitor(%v)", source))
 
-	m := newMonitor(c, &source, c.mconfig)
+	config := c.mconfig
+	config.init = source.init
+	if c.restarts.restarted(source) {
+		config.previous = c.previous
+		config.delay = config.backoff.delay(1)
+		c.restarts.clear(source)
+	}
+
+	m := newMonitor(c, &source, config)
 
 	go func() {
 
@@ -235,7 +345,7 @@ func (c *controller) createMonitor(source eventSource) monitor {
 		}
 
 		select {
-		case c.monitorch <- source:
+		case c.monitorch <- monitorExit{source, m}:
 		case <-c.lc.Done():
 			c.log.Warnf("done before monitor %v unregistered", source)
 		}
@@ -2,47 +2,261 @@ package kail
 
 import (
 	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	logutil "github.com/boz/go-logutil"
-	"github.com/boz/kcache/types/daemonset"
-	"github.com/boz/kcache/types/deployment"
-	"github.com/boz/kcache/types/ingress"
-	"github.com/boz/kcache/types/node"
+	"github.com/boz/kcache"
+	"github.com/boz/kcache/nsname"
 	"github.com/boz/kcache/types/pod"
-	"github.com/boz/kcache/types/replicaset"
-	"github.com/boz/kcache/types/replicationcontroller"
-	"github.com/boz/kcache/types/service"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/kubernetes"
 )
 
 type DS interface {
+	// Pods is the controller of the matched pods.  Refresh and Reconfigure
+	// replace it and close the previous one; Events, Subscribe, Stream and the pod
+	// handlers follow the matched pods across the replacement.
 	Pods() pod.Controller
+
+	// PodBase is the unfiltered pod controller from which Pods is derived,
+	// for callers composing their own filters without another list and
+	// watch.  It belongs to the datastore, or to the caller of
+	// NewSharedDSBuilder, and must not be closed.
+	PodBase() pod.Controller
+
+	// Ready is closed once all controllers have synced.  If any controller
+	// finishes before becoming ready, the datastore shuts down and Ready is
+	// never closed; callers must select on both Ready and Done.
 	Ready() <-chan struct{}
 	Done() <-chan struct{}
 	Close()
+
+	// ReadyContext blocks until the datastore is ready, returning nil, or
+	// until it is done without becoming ready or ctx is done, returning
+	// why.
+	ReadyContext(ctx context.Context) error
+
+	// Closed reports whether the datastore has been closed or is done.  It
+	// never blocks.
+	Closed() bool
+
+	// Progress delivers an update as each controller becomes ready.  It is
+	// closed once the datastore is ready or has failed to become ready.
+	Progress() <-chan ReadyUpdate
+
+	// ReadyErr describes why the datastore failed to become ready, such as
+	// the controller that finished early.  It is nil until Done is closed
+	// without Ready having been closed.
+	ReadyErr() error
+
+	// CloseAndWait closes the datastore and blocks until it is done or ctx
+	// is done.
+	CloseAndWait(ctx context.Context) error
+
+	// Since is how far back each log stream starts, relative to when the
+	// stream is opened.  Zero streams only new logs.
+	Since() time.Duration
+
+	// Tail is the number of existing lines to display when a log stream is
+	// first opened.  Negative values display all available lines; zero
+	// defers to Since.
+	Tail() int64
+
+	// Timestamps reports whether log lines are prefixed with the RFC3339Nano
+	// time recorded by the kubelet.
+	Timestamps() bool
+
+	// ContainerFilter selects which containers of matched pods are streamed.
+	ContainerFilter() ContainerFilter
+
+	// InitContainers reports whether init container logs are streamed in
+	// addition to those of regular containers.
+	InitContainers() bool
+
+	// Previous reports whether the logs of a container's previous instance
+	// are displayed when a restart is detected.
+	Previous() bool
+
+	// OrderedOutput is the window over which log lines from all sources are
+	// buffered and sorted by timestamp before being emitted.  Zero emits
+	// lines in arrival order.
+	OrderedOutput() time.Duration
+
+	// ReconnectBackoff is the range of delays before reopening a log
+	// stream.  Zero reopens streams immediately.
+	ReconnectBackoff() (initial, max time.Duration)
+
+	// MaxConcurrentStreams is the cap on open log streams.  Zero means no
+	// cap.
+	MaxConcurrentStreams() int
+
+	// Clock is the clock set with WithClock.
+	Clock() clock.Clock
+
+	Metrics() Metrics
+
+	// AddNamespace widens the namespaces given with WithNamespace or
+	// WithNamespaceSelector to include the named namespace, whether or not
+	// the selector matches it.  Pods in it that meet the other criteria are
+	// added to Pods() as they would be if they had just been created, and
+	// begin streaming.  It has no effect if pods are not restricted by
+	// namespace or the namespace is already watched.  If only a single
+	// namespace was watched, the datastore's controllers are rebuilt as by
+	// Refresh to watch the wider set.
+	AddNamespace(name string) error
+
+	// Reconfigure replaces the datastore's selection criteria, including
+	// joins and namespaces added with AddNamespace, with those of b.  It
+	// builds a new set of controllers from b and, once they are ready, swaps
+	// them in for the current ones, which are closed.  Readers of Events,
+	// Subscribe, Stream and the pod handlers see only the pods that leave or
+	// join the matched set, and streams of pods that match both continue
+	// uninterrupted.  Options other than selection criteria, such as
+	// WithSince and WithEvents, are kept as they were.  Reconfigure blocks
+	// until the datastore is ready and the new controllers are in place.
+	Reconfigure(b DSBuilder) error
+
+	// Refresh relists every watched resource from the API server.  It builds
+	// a new set of controllers from the datastore's criteria, applying
+	// time-relative criteria such as WithNewerThan again, and once they are
+	// ready swaps them in for the current ones, which are closed.  Readers of
+	// Events, Subscribe, Stream and the pod handlers see only the pods that
+	// were added, removed or changed in between.  A pod controller shared
+	// with NewSharedDSBuilder is not relisted.  Refresh blocks until the new
+	// controllers are in place, ctx is done or the datastore is closed.
+	Refresh(ctx context.Context) error
+
+	// Get returns the cached pod with the given id.  It returns false both
+	// when no such pod exists and when the pod exists but does not match the
+	// datastore's criteria; the two cases are not distinguished.
+	Get(id nsname.NSName) (*v1.Pod, bool)
+
+	// MatchReasons describes why the pod is in the matched set: the node,
+	// services, rcs, rss, dss and deployments given as criteria that
+	// select it, such as "service default/web".  Criteria evaluated
+	// directly against pods are not described.  It returns nil if the pod
+	// is not matched.
+	MatchReasons(id nsname.NSName) []string
+
+	// KubeEvents delivers cluster events, such as scheduling failures and
+	// image pull errors, involving pods currently in the matched set.  It is
+	// nil unless enabled with WithEvents, and is closed after the datastore
+	// is closed.  Events are dropped if the channel is not kept drained.
+	KubeEvents() <-chan *v1.Event
+
+	// Stream waits for the datastore to be ready and then streams the logs
+	// of the matched pods' containers, honoring the datastore's log and
+	// container options, as NewDSController does.  The channel is closed
+	// once ctx is done or the datastore is closed and every buffered line
+	// has been delivered.
+	Stream(ctx context.Context) (<-chan Event, error)
+
+	// Events returns a new channel of changes to the matched pod set,
+	// starting with an added event for each pod already matched once the
+	// datastore is ready, followed by a DSEventInitialListComplete event.
+	// Each channel is buffered independently; if its reader falls more than
+	// the buffer behind, the oldest buffered events are dropped, counted in
+	// Stats and logged.  Other channels are not affected.  The channel is
+	// closed when the datastore is done.
+	Events() <-chan DSEvent
+
+	// Subscribe is Events for callers that may stop reading before the
+	// datastore is done: closing the subscription releases it, leaving the
+	// datastore and other subscribers running.
+	Subscribe() DSSubscription
+
+	// Stats describes the datastore's current state.
+	Stats() DSStats
+
+	// ResourceVersion is the highest resource version among the matched
+	// pods, or empty if there are none.  It is suitable for checkpointing;
+	// resource versions are compared numerically where possible.
+	ResourceVersion() string
+
+	// Snapshot returns the matched pods sorted by namespace, then name, then
+	// creation time.  The returned slice is the caller's; the pods are shared
+	// with the cache and must not be modified.
+	Snapshot() []*v1.Pod
 }
 
 type datastore struct {
-	podBase         pod.Controller
-	servicesBase    service.Controller
-	nodesBase       node.Controller
-	rcsBase         replicationcontroller.Controller
-	rssBase         replicaset.Controller
-	dssBase         daemonset.Controller
-	deploymentsBase deployment.Controller
-	ingressesBase   ingress.Controller
-
-	pods        pod.Controller
-	services    service.Controller
-	nodes       node.Controller
-	rcs         replicationcontroller.Controller
-	rss         replicaset.Controller
-	dss         daemonset.Controller
-	deployments deployment.Controller
-	ingresses   ingress.Controller
-
-	readych chan struct{}
-	donech  chan struct{}
-	log     logutil.Log
+	// current is the chain computing the matched pods.  It is replaced by
+	// Refresh and Reconfigure.
+	current *dsChain
+	chainMu sync.Mutex
+
+	// feeds are the subscriptions moved to each new chain.
+	feeds map[*podFeed]bool
+
+	// updateMu serializes changes to the chain.
+	updateMu sync.Mutex
+
+	// pending tracks chains being built or retired.
+	pending sync.WaitGroup
+
+	// ctx and tracer are those the datastore was created with, used to
+	// build each chain.  ctx is cancelled when the datastore is closed.
+	ctx    context.Context
+	cancel context.CancelFunc
+	tracer Tracer
+
+	// quietMissing disables warnings for named resources that do not exist.
+	quietMissing bool
+
+	// cs is used by Stream to read logs.
+	cs kubernetes.Interface
+
+	since      time.Duration
+	tail       int64
+	timestamps bool
+	previous   bool
+	ordered    time.Duration
+	backoff    reconnectBackoff
+	reconnects uint64
+	streams    *streamLimiter
+
+	containerInclude []string
+	containerExclude []string
+	initContainers   bool
+
+	kubeEventsch chan *v1.Event
+
+	broadcaster   *broadcaster
+	broadcasterMu sync.Mutex
+
+	metrics           Metrics
+	podsub            pod.Subscription
+	onPodAdd          []func(*v1.Pod)
+	onPodRemove       []func(*v1.Pod)
+	controllersActive int32
+
+	readych    chan struct{}
+	readySpan  Span
+	progressch chan ReadyUpdate
+	readyErr   error
+	readyErrMu sync.Mutex
+
+	clock clock.Clock
+
+	// readyTimes holds how long after the datastore was created each
+	// controller and stage took to become ready.
+	created      time.Time
+	readyTimes   map[string]time.Duration
+	readyTimesMu sync.Mutex
+
+	donech    chan struct{}
+	closech   chan struct{}
+	closeOnce sync.Once
+	closed    int32
+	grace     time.Duration
+	log       logutil.Log
 }
 
 type cacheController interface {
@@ -52,13 +266,361 @@ type cacheController interface {
 }
 
 func (ds *datastore) Pods() pod.Controller {
-	return ds.pods
+	return ds.chain().pods
+}
+
+func (ds *datastore) PodBase() pod.Controller {
+	return ds.chain().podBase
+}
+
+// chain returns the current chain.
+func (ds *datastore) chain() *dsChain {
+	ds.chainMu.Lock()
+	defer ds.chainMu.Unlock()
+	return ds.current
+}
+
+func (ds *datastore) Since() time.Duration {
+	return ds.since
+}
+
+func (ds *datastore) Tail() int64 {
+	return ds.tail
+}
+
+func (ds *datastore) Timestamps() bool {
+	return ds.timestamps
+}
+
+func (ds *datastore) ContainerFilter() ContainerFilter {
+	return containerFilter{ds.containerInclude, ds.containerExclude, ds.initContainers}
+}
+
+func (ds *datastore) InitContainers() bool {
+	return ds.initContainers
+}
+
+func (ds *datastore) Previous() bool {
+	return ds.previous
+}
+
+func (ds *datastore) OrderedOutput() time.Duration {
+	return ds.ordered
+}
+
+func (ds *datastore) ReconnectBackoff() (time.Duration, time.Duration) {
+	return ds.backoff.initial, ds.backoff.max
+}
+
+func (ds *datastore) MaxConcurrentStreams() int {
+	return cap(ds.streams.sem)
+}
+
+func (ds *datastore) Clock() clock.Clock {
+	return ds.clock
+}
+
+func (ds *datastore) Metrics() Metrics {
+	return ds.metrics
+}
+
+func (ds *datastore) AddNamespace(name string) error {
+	if name == "" {
+		return fmt.Errorf("invalid namespace: empty name")
+	}
+
+	ds.updateMu.Lock()
+	defer ds.updateMu.Unlock()
+
+	c := ds.chain()
+	if c.podNamespace != "" {
+		// the base pod controller watches a single namespace, so a chain
+		// watching the wider set is built in its place.
+		b := c.builder()
+		if b.foldName(name) == c.podNamespace {
+			return nil
+		}
+		nb := b.Clone().(*dsBuilder)
+		nb.addedNs = append(nb.addedNs, name)
+		if err := ds.rebuild(ds.ctx, nb); err != nil {
+			return ds.log.Err(err, "adding namespace %v", name)
+		}
+		return nil
+	}
+
+	c.criteriaMu.Lock()
+	defer c.criteriaMu.Unlock()
+
+	b := c.criteriaBuilder
+	if b.allNamespaces || (len(b.namespaces) == 0 && b.nsSelector == nil) ||
+		containsName(b.namespaces, name) || containsName(b.addedNs, name) {
+		return nil
+	}
+
+	b.addedNs = append(b.addedNs, name)
+	if err := c.criteria.Refilter(b.podFilter()); err != nil {
+		b.addedNs = b.addedNs[:len(b.addedNs)-1]
+		return ds.log.Err(err, "adding namespace %v", name)
+	}
+	if c.nsStage != nil {
+		c.nsStage.refresh()
+	}
+	return nil
+}
+
+func (ds *datastore) Reconfigure(builder DSBuilder) error {
+	b, ok := builder.(*dsBuilder)
+	if !ok {
+		return fmt.Errorf("unsupported builder %T", builder)
+	}
+	if err := b.Validate(); err != nil {
+		return err
+	}
+	b = b.normalize()
+
+	ctx := context.Background()
+	if err := ds.ReadyContext(ctx); err != nil {
+		return err
+	}
+
+	ds.updateMu.Lock()
+	defer ds.updateMu.Unlock()
+	return ds.rebuild(ctx, b)
+}
+
+func (ds *datastore) Refresh(ctx context.Context) error {
+	if err := ds.ReadyContext(ctx); err != nil {
+		return err
+	}
+
+	ds.updateMu.Lock()
+	defer ds.updateMu.Unlock()
+
+	b := ds.chain().builder().Clone().(*dsBuilder)
+	return ds.rebuild(ctx, b)
+}
+
+// rebuild builds a new chain from b and, once it is ready, swaps it in for
+// the current chain, which is closed.  Subscriptions made with
+// subscribePods move to the new chain.
+func (ds *datastore) rebuild(ctx context.Context, b *dsBuilder) error {
+	ds.chainMu.Lock()
+	if ds.Closed() {
+		ds.chainMu.Unlock()
+		return fmt.Errorf("datastore closed")
+	}
+	ds.pending.Add(1)
+	ds.chainMu.Unlock()
+	defer ds.pending.Done()
+
+	c, err := b.createChain(ds.ctx, ds.cs, ds.tracer, logutil.FromContextOrDefault(ds.ctx))
+	if err != nil {
+		return err
+	}
+
+	readych := make(chan error, 1)
+	go func() { readych <- c.waitReady(ds.closech) }()
+
+	select {
+	case err = <-readych:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		c.abort()
+		return ds.log.Err(err, "rebuilding controllers")
+	}
+
+	ds.chainMu.Lock()
+	if ds.Closed() {
+		ds.chainMu.Unlock()
+		c.abort()
+		return fmt.Errorf("datastore closed")
+	}
+	old := ds.current
+	ds.current = c
+
+	feeds := make(map[*podFeed]pod.Subscription, len(ds.feeds))
+	for f := range ds.feeds {
+		sub, err := c.pods.Subscribe()
+		if err != nil {
+			ds.log.ErrWarn(err, "moving pod subscription")
+			f.Close()
+			continue
+		}
+		feeds[f] = sub
+	}
+	ds.chainMu.Unlock()
+
+	for f, sub := range feeds {
+		f.swap(sub)
+	}
+	close(old.replacedch)
+	old.abort()
+
+	ds.trackControllers(c)
+	if !ds.quietMissing {
+		c.warnMissing()
+	}
+	return nil
+}
+
+// subscribePods subscribes to the matched pods.  Unlike a subscription of
+// Pods(), the subscription follows Refresh and Reconfigure.
+func (ds *datastore) subscribePods() (pod.Subscription, error) {
+	ds.chainMu.Lock()
+	defer ds.chainMu.Unlock()
+
+	sub, err := ds.current.pods.Subscribe()
+	if err != nil {
+		return nil, err
+	}
+
+	f := newPodFeed(sub, ds.log)
+	ds.feeds[f] = true
+	go func() {
+		<-f.Done()
+		ds.chainMu.Lock()
+		defer ds.chainMu.Unlock()
+		delete(ds.feeds, f)
+	}()
+	return f, nil
+}
+
+func (ds *datastore) Get(id nsname.NSName) (*v1.Pod, bool) {
+	return ds.chain().get(id)
+}
+
+func (ds *datastore) KubeEvents() <-chan *v1.Event {
+	return ds.kubeEventsch
+}
+
+func (ds *datastore) Stream(ctx context.Context) (<-chan Event, error) {
+	if err := ds.ReadyContext(ctx); err != nil {
+		return nil, err
+	}
+
+	controller, err := NewDSController(ctx, ds.cs, nil, ds)
+	if err != nil {
+		return nil, err
+	}
+	return controller.Events(), nil
+}
+
+func (ds *datastore) Events() <-chan DSEvent {
+	return ds.Subscribe().Events()
+}
+
+func (ds *datastore) Subscribe() DSSubscription {
+	ds.broadcasterMu.Lock()
+	defer ds.broadcasterMu.Unlock()
+
+	if ds.broadcaster == nil {
+		sub, err := ds.subscribePods()
+		if err != nil {
+			ds.log.ErrWarn(err, "subscribing to pods")
+			s := &subscriber{ch: make(chan DSEvent)}
+			close(s.ch)
+			return s
+		}
+		ds.broadcaster = newBroadcaster(sub, ds.log)
+	}
+	return ds.broadcaster.subscribe()
+}
+
+func (ds *datastore) ResourceVersion() string {
+	pods, err := ds.chain().pods.Cache().List()
+	if err != nil {
+		return ""
+	}
+
+	var highest string
+	var highestN uint64
+	for _, pod := range pods {
+		rv := pod.GetResourceVersion()
+		n, err := strconv.ParseUint(rv, 10, 64)
+		switch {
+		case err != nil:
+			if highest == "" {
+				highest = rv
+			}
+		case n > highestN:
+			highest, highestN = rv, n
+		}
+	}
+	return highest
+}
+
+func (ds *datastore) Snapshot() []*v1.Pod {
+	pods, err := ds.chain().pods.Cache().List()
+	if err != nil {
+		ds.log.ErrWarn(err, "listing pods")
+		return nil
+	}
+
+	pods = append([]*v1.Pod(nil), pods...)
+	sort.Slice(pods, func(i, j int) bool {
+		a, b := pods[i], pods[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.CreationTimestamp.Time.Before(b.CreationTimestamp.Time)
+	})
+	return pods
 }
 
 func (ds *datastore) Ready() <-chan struct{} {
 	return ds.readych
 }
 
+func (ds *datastore) Closed() bool {
+	return atomic.LoadInt32(&ds.closed) != 0
+}
+
+func (ds *datastore) ReadyContext(ctx context.Context) error {
+	select {
+	case <-ds.readych:
+		return nil
+	case <-ds.donech:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	// a datastore closed after becoming ready is done with both closed.
+	select {
+	case <-ds.readych:
+		return nil
+	default:
+	}
+	if err := ds.ReadyErr(); err != nil {
+		return err
+	}
+	return fmt.Errorf("datastore done before ready")
+}
+
+func (ds *datastore) Progress() <-chan ReadyUpdate {
+	return ds.progressch
+}
+
+func (ds *datastore) ReadyErr() error {
+	ds.readyErrMu.Lock()
+	defer ds.readyErrMu.Unlock()
+	return ds.readyErr
+}
+
+// setReadyErr records the first reason the datastore failed to become
+// ready.
+func (ds *datastore) setReadyErr(err error) {
+	ds.readyErrMu.Lock()
+	defer ds.readyErrMu.Unlock()
+	if ds.readyErr == nil {
+		ds.readyErr = err
+	}
+}
+
 func (ds *datastore) Done() <-chan struct{} {
 	return ds.donech
 }
@@ -67,69 +629,318 @@ func (ds *datastore) Close() {
 	ds.closeAll()
 }
 
+func (ds *datastore) CloseAndWait(ctx context.Context) error {
+	ds.Close()
+	select {
+	case <-ds.Done():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// DSStats describes a datastore's current state.
+type DSStats struct {
+	// EventsDropped holds, for each open subscription or channel returned
+	// by Events in the order they were created, the number of events
+	// dropped because its reader fell behind.
+	EventsDropped []uint64
+
+	// ReadyTimes holds, for each controller and stage that has become
+	// ready, how long after the datastore was created it did so.  It is
+	// complete once the datastore is ready.
+	ReadyTimes map[string]time.Duration
+
+	// Reconnects is the number of times a log stream has been reopened.
+	Reconnects uint64
+
+	// StreamsOpen is the number of log streams currently open, and
+	// StreamsQueued the number waiting for WithMaxConcurrentStreams to
+	// allow them to open.
+	StreamsOpen   int
+	StreamsQueued int
+}
+
+func (ds *datastore) Stats() DSStats {
+	var stats DSStats
+
+	ds.broadcasterMu.Lock()
+	if ds.broadcaster != nil {
+		stats.EventsDropped = ds.broadcaster.dropped()
+	}
+	ds.broadcasterMu.Unlock()
+
+	ds.readyTimesMu.Lock()
+	stats.ReadyTimes = make(map[string]time.Duration, len(ds.readyTimes))
+	for name, d := range ds.readyTimes {
+		stats.ReadyTimes[name] = d
+	}
+	ds.readyTimesMu.Unlock()
+
+	stats.Reconnects = atomic.LoadUint64(&ds.reconnects)
+	stats.StreamsOpen, stats.StreamsQueued = ds.streams.counts()
+
+	return stats
+}
+
+// ReadyUpdate reports that the named controller or stage has become ready,
+// and how many of the datastore's total are ready so far.
+type ReadyUpdate struct {
+	Name  string
+	Ready int
+	Total int
+}
+
 func (ds *datastore) run(ctx context.Context) {
-	go func() {
+	c := ds.chain()
+
+	// buffered so that updates never block readiness.
+	ds.progressch = make(chan ReadyUpdate, len(c.controllers())+len(c.stages))
+
+	go ds.watchContext(ctx)
+	ds.timeReadyAll(c)
+	go ds.waitReadyAll(c)
+	go ds.waitDoneAll()
+
+	if ds.podsub != nil {
+		go ds.watchPods()
+		ds.trackControllers(c)
+	}
+}
+
+// watchPods dispatches changes to the matched pod set to metrics and pod
+// handlers.  Once the initial set is available, handlers are sent an add
+// for each pod in it, and then its changes.
+func (ds *datastore) watchPods() {
+	defer ds.podsub.Close()
+
+	var handlers *podHandlers
+	if len(ds.onPodAdd) > 0 || len(ds.onPodRemove) > 0 {
+		handlers = newPodHandlers(ds.onPodAdd, ds.onPodRemove)
+		defer handlers.end()
+	}
+
+	readych := ds.podsub.Ready()
+	ready := false
+
+	for {
 		select {
-		case <-ctx.Done():
-			ds.Close()
-		case <-ds.Done():
+		case <-readych:
+			readych = nil
+			ready = true
+			if pods, err := ds.podsub.Cache().List(); err == nil {
+				for _, pod := range pods {
+					handlers.added(pod)
+				}
+			}
+
+		case ev, ok := <-ds.podsub.Events():
+			if !ok {
+				return
+			}
+			if !ready {
+				continue
+			}
+			switch ev.Type() {
+			case kcache.EventTypeCreate:
+				handlers.added(ev.Resource())
+			case kcache.EventTypeDelete:
+				handlers.removed(ev.Resource())
+			}
+
+		case <-ds.podsub.Done():
+			return
 		}
-	}()
 
-	go ds.waitReadyAll()
-	go ds.waitDoneAll()
+		if pods, err := ds.podsub.Cache().List(); err == nil {
+			ds.metrics.PodsWatched(len(pods))
+		}
+	}
+}
+
+// trackControllers reports the number of ready controllers of chain as they
+// become ready and done.
+func (ds *datastore) trackControllers(chain *dsChain) {
+	for _, c := range chain.controllers() {
+		go func(c cacheController) {
+			select {
+			case <-c.Ready():
+			case <-c.Done():
+				return
+			}
+			ds.metrics.ControllersActive(int(atomic.AddInt32(&ds.controllersActive, 1)))
+			<-c.Done()
+			ds.metrics.ControllersActive(int(atomic.AddInt32(&ds.controllersActive, -1)))
+		}(c)
+	}
 }
 
-func (ds *datastore) waitReadyAll() {
-	for _, c := range ds.controllers() {
+// watchContext closes the datastore when ctx is cancelled.  It returns
+// once the datastore is done so that it does not outlive an explicit Close.
+func (ds *datastore) watchContext(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		ds.Close()
+	case <-ds.Done():
+	}
+}
+
+func (ds *datastore) waitReadyAll(chain *dsChain) {
+	defer close(ds.progressch)
+	defer func() { endSpan(ds.readySpan, ds.ReadyErr()) }()
+
+	controllers := chain.controllers()
+	total := len(controllers) + len(chain.stages)
+	ready := 0
+
+	for _, c := range controllers {
 		select {
 		case <-c.Done():
+			name := chain.controllerName(c)
+			ds.log.Warnf("%v controller done before ready", name)
+			ds.setReadyErr(fmt.Errorf("%v controller done before ready", name))
+			ds.closeAll()
 			return
 		case <-c.Ready():
+			ready++
+			ds.progressch <- ReadyUpdate{chain.controllerName(c), ready, total}
+		}
+	}
+	for _, s := range chain.stages {
+		select {
+		case <-chain.pods.Done():
+			ds.log.Warnf("stage %v done before ready", s.name)
+			ds.setReadyErr(fmt.Errorf("%v stage done before ready", s.name))
+			ds.closeAll()
+			return
+		case <-s.readych:
+			ready++
+			ds.progressch <- ReadyUpdate{s.name + " stage", ready, total}
 		}
 	}
 	close(ds.readych)
+
+	if !ds.quietMissing {
+		chain.warnMissing()
+	}
 }
 
-func (ds *datastore) closeAll() {
-	for _, c := range ds.controllers() {
-		c.Close()
+// timeReadyAll records how long each controller and stage of chain takes to
+// become ready.  Each is timed independently, as waitReadyAll observes them
+// in order.
+func (ds *datastore) timeReadyAll(chain *dsChain) {
+	ds.readyTimes = make(map[string]time.Duration)
+
+	record := func(name string, readych <-chan struct{}) {
+		select {
+		case <-readych:
+			ds.readyTimesMu.Lock()
+			ds.readyTimes[name] = ds.clock.Since(ds.created)
+			ds.readyTimesMu.Unlock()
+		case <-ds.donech:
+		}
+	}
+
+	for _, c := range chain.controllers() {
+		go record(chain.controllerName(c), c.Ready())
 	}
+	for _, s := range chain.stages {
+		go record(s.name+" stage", s.readych)
+	}
+}
+
+func (ds *datastore) closeAll() {
+	ds.closeOnce.Do(func() {
+		// no chain is swapped in once closed is set.
+		ds.chainMu.Lock()
+		atomic.StoreInt32(&ds.closed, 1)
+		c := ds.current
+		ds.chainMu.Unlock()
+
+		close(ds.closech)
+		if ds.cancel != nil {
+			ds.cancel()
+		}
+		c.close()
+	})
+}
+
+// abort tears down a partially-built datastore, blocking until every
+// controller created so far has completed.
+func (ds *datastore) abort() {
+	ds.closeAll()
+	ds.waitDoneAll()
 }
 
+// waitDoneAll closes donech once every controller has completed, following
+// the current chain as it is replaced.  If a grace period is set and the
+// controllers have not completed within it of the datastore being closed,
+// the remaining ones are abandoned.
 func (ds *datastore) waitDoneAll() {
 	defer close(ds.donech)
-	for _, c := range ds.controllers() {
-		<-c.Done()
+	defer atomic.StoreInt32(&ds.closed, 1)
+
+	closech := ds.closech
+	var timeout <-chan time.Time
+	expired := false
+
+	chain := ds.chain()
+	controllers := chain.controllers()
+	for i := 0; i < len(controllers); {
+		select {
+		case <-controllers[i].Done():
+			i++
+		case <-chain.replacedch:
+			// the previous chain is retired by rebuild.
+			chain = ds.chain()
+			controllers, i = chain.controllers(), 0
+		case <-closech:
+			closech = nil
+			if ds.grace > 0 {
+				timeout = ds.clock.After(ds.grace)
+			}
+		case <-timeout:
+			var names []string
+			for _, c := range controllers[i:] {
+				select {
+				case <-c.Done():
+				default:
+					names = append(names, chain.controllerName(c))
+				}
+			}
+			ds.log.Warnf("shutdown grace period (%v) expired waiting for controllers: %v",
+				ds.grace, strings.Join(names, ", "))
+			i = len(controllers)
+			expired = true
+		}
+	}
+
+	// a chain being built or retired must not outlive the datastore.
+	ds.closeAll()
+	if !expired {
+		if timeout == nil && ds.grace > 0 {
+			timeout = ds.clock.After(ds.grace)
+		}
+		pendingch := make(chan struct{})
+		go func() {
+			ds.pending.Wait()
+			close(pendingch)
+		}()
+		select {
+		case <-pendingch:
+		case <-timeout:
+			ds.log.Warnf("shutdown grace period (%v) expired waiting for replaced controllers", ds.grace)
+		}
+	}
+
+	select {
+	case <-ds.readych:
+	default:
+		ds.setReadyErr(fmt.Errorf("datastore closed before ready"))
 	}
 }
 
+// controllers returns the controllers of the current chain.
 func (ds *datastore) controllers() []cacheController {
-
-	potential := []cacheController{
-		ds.podBase,
-		ds.servicesBase,
-		ds.nodesBase,
-		ds.rcsBase,
-		ds.rssBase,
-		ds.dssBase,
-		ds.deploymentsBase,
-		ds.ingressesBase,
-		ds.pods,
-		ds.services,
-		ds.nodes,
-		ds.rcs,
-		ds.rss,
-		ds.dss,
-		ds.deployments,
-		ds.ingresses,
-	}
-
-	var existing []cacheController
-	for _, c := range potential {
-		if c != nil {
-			existing = append(existing, c)
-		}
-	}
-	return existing
+	return ds.chain().controllers()
 }
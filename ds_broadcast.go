@@ -0,0 +1,328 @@
+package kail
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	logutil "github.com/boz/go-logutil"
+	"github.com/boz/kcache"
+	"github.com/boz/kcache/types/pod"
+	"k8s.io/api/core/v1"
+)
+
+const dsEventBufsiz = 500
+
+type DSEventType int
+
+const (
+	DSEventAdded DSEventType = iota
+	DSEventUpdated
+	DSEventRemoved
+
+	// DSEventInitialListComplete follows the added events for the pods
+	// matched when a subscriber starts receiving events.  It has no pod.
+	DSEventInitialListComplete
+)
+
+func (t DSEventType) String() string {
+	switch t {
+	case DSEventAdded:
+		return "added"
+	case DSEventUpdated:
+		return "updated"
+	case DSEventRemoved:
+		return "removed"
+	case DSEventInitialListComplete:
+		return "initial-list-complete"
+	}
+	return fmt.Sprintf("DSEventType(%d)", int(t))
+}
+
+// DSEvent is a change to the matched pod set.
+type DSEvent struct {
+	Type DSEventType
+	Pod  *v1.Pod
+}
+
+// DSSubscription is a channel of changes to the matched pod set that can be
+// closed without closing the datastore.
+type DSSubscription interface {
+	// Events is closed once the subscription or the datastore is closed.
+	Events() <-chan DSEvent
+
+	// Close stops delivery and releases the subscription's buffer.  It may
+	// be called more than once.
+	Close()
+}
+
+// broadcaster fans changes to the matched pod set out to any number of
+// subscribers, each with its own bounded buffer.  When a subscriber's
+// buffer is full its oldest event is dropped to make room, so that a slow
+// reader cannot stall the others or the underlying controllers.  The
+// DSEventInitialListComplete event is never dropped, and a subscriber's
+// channel is closed only after its buffer has been delivered.
+type broadcaster struct {
+	sub          pod.Subscription
+	registerch   chan *subscriber
+	unregisterch chan *subscriber
+	donech       chan struct{}
+	log          logutil.Log
+
+	// subscribers is written by run and read by dropped.
+	subscribers []*subscriber
+	mu          sync.Mutex
+}
+
+type subscriber struct {
+	ch      chan DSEvent
+	dropped uint64
+
+	// queue holds the events not yet handed to ch.  ended is set once no
+	// more events will be queued.
+	queue     []DSEvent
+	ended     bool
+	mu        sync.Mutex
+	pendingch chan struct{}
+
+	// stopch is closed when the reader closes the subscription.
+	stopch   chan struct{}
+	stopOnce sync.Once
+
+	b         *broadcaster
+	closeOnce sync.Once
+}
+
+func newSubscriber(b *broadcaster) *subscriber {
+	return &subscriber{
+		ch:        make(chan DSEvent),
+		pendingch: make(chan struct{}, 1),
+		stopch:    make(chan struct{}),
+		b:         b,
+	}
+}
+
+func (s *subscriber) Events() <-chan DSEvent {
+	return s.ch
+}
+
+// Close asks the broadcaster, which owns the channel, to close it.
+func (s *subscriber) Close() {
+	if s.b == nil {
+		// never registered; the channel is already closed.
+		return
+	}
+	s.closeOnce.Do(func() {
+		select {
+		case s.b.unregisterch <- s:
+		case <-s.b.donech:
+			s.stop()
+		}
+	})
+}
+
+// run delivers queued events to ch until the subscription is stopped, or
+// has ended and every queued event has been delivered.
+func (s *subscriber) run() {
+	defer close(s.ch)
+	for {
+		ev, ok := s.next()
+		if !ok {
+			return
+		}
+		select {
+		case s.ch <- ev:
+		case <-s.stopch:
+			return
+		}
+	}
+}
+
+// next removes and returns the oldest queued event, blocking until there is
+// one.  It returns false once the subscription is stopped, or has ended
+// with nothing queued.
+func (s *subscriber) next() (DSEvent, bool) {
+	for {
+		s.mu.Lock()
+		if len(s.queue) > 0 {
+			ev := s.queue[0]
+			s.queue = s.queue[1:]
+			s.mu.Unlock()
+			return ev, true
+		}
+		ended := s.ended
+		s.mu.Unlock()
+
+		if ended {
+			return DSEvent{}, false
+		}
+		select {
+		case <-s.pendingch:
+		case <-s.stopch:
+			return DSEvent{}, false
+		}
+	}
+}
+
+// end closes ch once the events already queued have been delivered.
+func (s *subscriber) end() {
+	s.mu.Lock()
+	s.ended = true
+	s.mu.Unlock()
+	s.signal()
+}
+
+// stop closes ch, discarding any queued events.
+func (s *subscriber) stop() {
+	s.stopOnce.Do(func() { close(s.stopch) })
+}
+
+func (s *subscriber) signal() {
+	select {
+	case s.pendingch <- struct{}{}:
+	default:
+	}
+}
+
+func newBroadcaster(sub pod.Subscription, log logutil.Log) *broadcaster {
+	b := &broadcaster{
+		sub:          sub,
+		registerch:   make(chan *subscriber),
+		unregisterch: make(chan *subscriber),
+		donech:       make(chan struct{}),
+		log:          log.WithComponent("kail.ds.broadcaster"),
+	}
+	go b.run()
+	return b
+}
+
+// subscribe returns a new subscriber.  Its channel is closed immediately if
+// the broadcaster is done.
+func (b *broadcaster) subscribe() *subscriber {
+	s := newSubscriber(b)
+	select {
+	case b.registerch <- s:
+		go s.run()
+	case <-b.donech:
+		close(s.ch)
+	}
+	return s
+}
+
+// dropped returns the number of events dropped for each open subscriber, in
+// the order they subscribed.
+func (b *broadcaster) dropped() []uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	counts := make([]uint64, 0, len(b.subscribers))
+	for _, s := range b.subscribers {
+		counts = append(counts, atomic.LoadUint64(&s.dropped))
+	}
+	return counts
+}
+
+func (b *broadcaster) run() {
+	defer close(b.donech)
+	defer b.sub.Close()
+
+	var subscribers []*subscriber
+
+	defer func() {
+		for _, s := range subscribers {
+			s.end()
+		}
+	}()
+
+	readych := b.sub.Ready()
+
+	for {
+		select {
+		case <-readych:
+			readych = nil
+			for _, s := range subscribers {
+				b.sendInitial(s)
+			}
+
+		case s := <-b.registerch:
+			subscribers = append(subscribers, s)
+			b.mu.Lock()
+			b.subscribers = subscribers
+			b.mu.Unlock()
+			if readych == nil {
+				b.sendInitial(s)
+			}
+
+		case s := <-b.unregisterch:
+			for i := range subscribers {
+				if subscribers[i] == s {
+					subscribers = append(subscribers[:i:i], subscribers[i+1:]...)
+					s.stop()
+					break
+				}
+			}
+			b.mu.Lock()
+			b.subscribers = subscribers
+			b.mu.Unlock()
+
+		case ev, ok := <-b.sub.Events():
+			if !ok {
+				return
+			}
+			if readych != nil {
+				continue
+			}
+
+			var dsev DSEvent
+			switch ev.Type() {
+			case kcache.EventTypeCreate:
+				dsev = DSEvent{DSEventAdded, ev.Resource()}
+			case kcache.EventTypeUpdate:
+				dsev = DSEvent{DSEventUpdated, ev.Resource()}
+			case kcache.EventTypeDelete:
+				dsev = DSEvent{DSEventRemoved, ev.Resource()}
+			default:
+				continue
+			}
+			for _, s := range subscribers {
+				b.send(s, dsev)
+			}
+
+		case <-b.sub.Done():
+			return
+		}
+	}
+}
+
+func (b *broadcaster) sendInitial(s *subscriber) {
+	pods, err := b.sub.Cache().List()
+	if err != nil {
+		b.log.ErrWarn(err, "listing initial pods")
+		return
+	}
+	for _, pod := range pods {
+		b.send(s, DSEvent{DSEventAdded, pod})
+	}
+	b.send(s, DSEvent{DSEventInitialListComplete, nil})
+}
+
+// send queues ev for s without blocking, dropping the oldest queued event
+// other than DSEventInitialListComplete if the queue is full.
+func (b *broadcaster) send(s *subscriber, ev DSEvent) {
+	s.mu.Lock()
+	if len(s.queue) >= dsEventBufsiz {
+		i := 0
+		if s.queue[i].Type == DSEventInitialListComplete {
+			i++
+		}
+		old := s.queue[i]
+		s.queue = append(s.queue[:i], s.queue[i+1:]...)
+
+		atomic.AddUint64(&s.dropped, 1)
+		b.log.Warnf("subscriber buffer full: dropping %v event for %v/%v",
+			old.Type, old.Pod.GetNamespace(), old.Pod.GetName())
+	}
+	s.queue = append(s.queue, ev)
+	s.mu.Unlock()
+
+	s.signal()
+}
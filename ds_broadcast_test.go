@@ -0,0 +1,139 @@
+package kail
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	logutil "github.com/boz/go-logutil"
+	"github.com/boz/kcache"
+	"github.com/boz/kcache/types/pod"
+	"k8s.io/api/core/v1"
+)
+
+// fakeSubscription is a pod subscription whose readiness and events are
+// driven by the test.
+type fakeSubscription struct {
+	cache   fakeCache
+	readych chan struct{}
+	eventch chan pod.Event
+	donech  chan struct{}
+}
+
+func newFakeSubscription(pods ...*v1.Pod) *fakeSubscription {
+	return &fakeSubscription{
+		cache:   fakeCache(pods),
+		readych: make(chan struct{}),
+		eventch: make(chan pod.Event),
+		donech:  make(chan struct{}),
+	}
+}
+
+func (s *fakeSubscription) Cache() pod.CacheReader   { return s.cache }
+func (s *fakeSubscription) Ready() <-chan struct{}   { return s.readych }
+func (s *fakeSubscription) Events() <-chan pod.Event { return s.eventch }
+func (s *fakeSubscription) Close()                   {}
+func (s *fakeSubscription) Done() <-chan struct{}    { return s.donech }
+
+func TestBroadcasterBlockedSubscriber(t *testing.T) {
+	tests := []struct {
+		name    string
+		initial int
+		events  int
+	}{
+		{"within buffer", 3, dsEventBufsiz / 2},
+		{"overflow", 3, dsEventBufsiz * 4},
+		{"initial overflow", dsEventBufsiz * 2, dsEventBufsiz},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var initial []*v1.Pod
+			for i := 0; i < test.initial; i++ {
+				initial = append(initial, testPod("default", fmt.Sprintf("initial-%v", i), nil))
+			}
+
+			sub := newFakeSubscription(initial...)
+			b := newBroadcaster(sub, logutil.FromContextOrDefault(context.Background()))
+
+			blocked := b.subscribe()
+			defer blocked.Close()
+
+			const fast = 3
+			counts := make([]chan int, fast)
+			for i := range counts {
+				counts[i] = make(chan int, 1)
+				go func(s *subscriber, countch chan<- int) {
+					n := 0
+					for range s.Events() {
+						n++
+					}
+					countch <- n
+				}(b.subscribe(), counts[i])
+			}
+
+			close(sub.readych)
+
+			// the source must keep advancing with a subscriber blocked.
+			for i := 0; i < test.events; i++ {
+				ev := podEvent{kcache.EventTypeUpdate, testPod("default", fmt.Sprintf("pod-%v", i), nil)}
+				select {
+				case sub.eventch <- ev:
+				case <-time.After(testTimeout):
+					t.Fatalf("source stalled after %v events", i)
+				}
+			}
+			close(sub.eventch)
+
+			want := test.initial + 1 + test.events
+			for i, countch := range counts {
+				select {
+				case n := <-countch:
+					if n != want {
+						t.Errorf("subscriber %v: got %v events, want %v", i, n, want)
+					}
+				case <-time.After(testTimeout):
+					t.Fatalf("subscriber %v not closed", i)
+				}
+			}
+
+			var got []DSEvent
+			timeout := time.After(testTimeout)
+		drain:
+			for {
+				select {
+				case ev, ok := <-blocked.Events():
+					if !ok {
+						break drain
+					}
+					got = append(got, ev)
+				case <-timeout:
+					t.Fatalf("blocked subscriber not closed")
+				}
+			}
+
+			sentinels := 0
+			for _, ev := range got {
+				if ev.Type == DSEventInitialListComplete {
+					sentinels++
+				}
+			}
+			if sentinels != 1 {
+				t.Errorf("blocked subscriber: got %v initial list complete events, want 1", sentinels)
+			}
+
+			// the blocked reader holds at most a full buffer and the event
+			// in flight to it; the rest are counted as dropped.
+			dropped := int(atomic.LoadUint64(&blocked.dropped))
+			if len(got)+dropped != want {
+				t.Errorf("blocked subscriber: got %v events and %v dropped, want %v in all",
+					len(got), dropped, want)
+			}
+			if want > dsEventBufsiz+1 && dropped == 0 {
+				t.Errorf("blocked subscriber: no events dropped")
+			}
+		})
+	}
+}
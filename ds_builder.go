@@ -2,54 +2,492 @@ package kail
 
 import (
 	"context"
+	"fmt"
+	"net"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
 
 	logutil "github.com/boz/go-logutil"
 	"github.com/boz/kcache/filter"
-	"github.com/boz/kcache/join"
 	"github.com/boz/kcache/nsname"
-	"github.com/boz/kcache/types/daemonset"
-	"github.com/boz/kcache/types/deployment"
-	"github.com/boz/kcache/types/ingress"
 	"github.com/boz/kcache/types/pod"
-	"github.com/boz/kcache/types/replicaset"
-	"github.com/boz/kcache/types/replicationcontroller"
-	"github.com/boz/kcache/types/service"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/util/clock"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 )
 
 type DSBuilder interface {
 	WithIgnore(selectors ...labels.Selector) DSBuilder
 	WithSelectors(selectors ...labels.Selector) DSBuilder
+
+	// WithLabel matches pods with the given label value.  It is shorthand
+	// for WithSelectors with the equivalent equality selector.
+	WithLabel(key, value string) DSBuilder
+
+	// WithLabelKey matches pods that have all of the given labels set,
+	// regardless of their values.
+	WithLabelKey(keys ...string) DSBuilder
+
+	// WithAnySelector matches pods that match at least one of the given
+	// selectors.  Unlike WithSelectors, whose selectors must all match, the
+	// selectors of a single call are alternatives; separate calls, and any
+	// other criteria, must still all match.
+	WithAnySelector(selectors ...labels.Selector) DSBuilder
+
+	// WithControllerRevision matches pods whose controller-revision-hash
+	// label, set by stateful sets and daemon sets, has any of the given
+	// values.  It is shorthand for WithAnySelector with an equality
+	// selector for each hash.
+	WithControllerRevision(hash ...string) DSBuilder
+
+	// WithPods matches the pods with the given ids.  If there are at most
+	// ten, each naming its namespace, and the pod controller is not shared,
+	// only those pods are listed and watched, each with a field selector on
+	// its name, rather than every pod in the namespaces searched.
 	WithPods(id ...nsname.NSName) DSBuilder
+
+	// WithPodName is WithPods for pods in a single namespace.
+	WithPodName(namespace string, names ...string) DSBuilder
+
+	// WithPodNameGlob matches pods whose names match any of the given
+	// shell-style patterns, such as "web-*", using path.Match syntax.
+	// Matching is case-sensitive, unless WithCaseInsensitiveNames is set,
+	// and applies to the name only; combine it with WithNamespace to
+	// restrict the namespaces searched.
+	WithPodNameGlob(patterns ...string) DSBuilder
+
+	// WithPodNameRegex matches pods whose names match any of the given
+	// regular expressions.  Expressions are unanchored.  An error is
+	// returned, and the builder left unchanged, if any fails to compile.
+	WithPodNameRegex(exprs ...string) (DSBuilder, error)
+
+	// WithCaseInsensitiveNames makes WithPods, WithNamespace,
+	// WithoutNamespace, WithPodNameGlob and WithPodNameRegex ignore case.
+	// The API only accepts lower-case names, so this merely forgives
+	// criteria typed in the wrong case; it is off by default.
+	WithCaseInsensitiveNames() DSBuilder
+
+	// WithNamespace restricts the matched pods to the given namespaces,
+	// overriding an earlier WithAllNamespaces.  If the criteria allow a
+	// single namespace only, and the pod controller is not shared, only
+	// pods in that namespace are listed and watched; otherwise every pod
+	// is, as kcache controllers watch one namespace or all of them, unless
+	// WithPods narrows the watch further.
 	WithNamespace(name ...string) DSBuilder
+
+	// WithNamespaceSelector matches pods in namespaces whose labels match the
+	// selector.  Namespaces leave the set, along with their pods, once they
+	// begin terminating.  It satisfies the namespace requirement of Create.
+	WithNamespaceSelector(selector labels.Selector) DSBuilder
+
+	// WithoutNamespace excludes pods in the named namespaces.
+	WithoutNamespace(name ...string) DSBuilder
+
+	// WithoutSystemNamespaces excludes pods in SystemNamespaces and in any
+	// of the extra namespaces given.
+	WithoutSystemNamespaces(extra ...string) DSBuilder
+
+	// WithAllNamespaces matches pods in every namespace, overriding any
+	// namespace criteria.  Create refuses to watch the whole cluster unless
+	// this was given, or the namespaces were named with WithNamespace,
+	// WithNamespaceSelector or the pod ids of WithPods.
+	WithAllNamespaces() DSBuilder
+
 	WithService(id ...nsname.NSName) DSBuilder
+
+	// WithServiceType matches pods targeted by services of any of the given
+	// types.  Combined with WithService, only the named services of those
+	// types are used.  Pods join and leave the set as service types change.
+	WithServiceType(types ...v1.ServiceType) DSBuilder
+
 	WithNode(name ...string) DSBuilder
+
+	// WithNodeSelector matches pods scheduled on nodes whose labels match
+	// all of the given selectors.  Pods join and leave the set as nodes
+	// gain and lose labels.
+	WithNodeSelector(selectors ...labels.Selector) DSBuilder
+
+	// WithNodeReady matches pods scheduled on nodes whose Ready condition
+	// is true, if ready is set, or is false or unknown otherwise.  Pods
+	// join and leave the set as nodes change readiness.  Unscheduled pods
+	// are never matched.
+	WithNodeReady(ready bool) DSBuilder
+
 	WithRC(id ...nsname.NSName) DSBuilder
 	WithRS(id ...nsname.NSName) DSBuilder
 	WithDS(id ...nsname.NSName) DSBuilder
 	WithDeployment(id ...nsname.NSName) DSBuilder
+
+	// WithDeploymentAllRevisions matches pods owned by any replica set owned
+	// by the given deployments, such as those of both the old and new
+	// revisions during a rollout.  Unlike WithDeployment, which matches
+	// pods by the deployment's selector, ownership is followed, so pods
+	// that merely match the selector are not included.
+	WithDeploymentAllRevisions(id ...nsname.NSName) DSBuilder
+
+	// WithDeploymentConfig matches pods of OpenShift DeploymentConfigs.  The
+	// replication controllers of each DeploymentConfig are found by the
+	// label OpenShift sets on them, so the apps.openshift.io API is not
+	// required.
+	WithDeploymentConfig(id ...nsname.NSName) DSBuilder
+
 	WithIngress(id ...nsname.NSName) DSBuilder
 
+	// WithPDB matches pods protected by any of the given
+	// PodDisruptionBudgets: those in a budget's namespace matching its
+	// selector.  Pods join and leave the set as budgets change.
+	WithPDB(id ...nsname.NSName) DSBuilder
+
+	// WithContainerState matches pods with a container that is waiting or
+	// terminated for one of the given reasons, such as CrashLoopBackOff or
+	// ImagePullBackOff.  Pods join and leave the set as their containers
+	// change state.
+	WithContainerState(reasons ...string) DSBuilder
+
+	// WithMinRestarts matches pods whose containers have restarted at least
+	// n times in total.  WithMinContainerRestarts instead requires a single
+	// container to have restarted n times.  Pods join the set as soon as
+	// they cross the threshold.
+	WithMinRestarts(n int32) DSBuilder
+	WithMinContainerRestarts(n int32) DSBuilder
+
+	// WithMinReadyContainers matches pods with at least n ready containers.
+	// Only regular containers are counted; init and ephemeral containers
+	// are not.
+	WithMinReadyContainers(n int) DSBuilder
+
+	// WithCondition matches pods reporting a condition of the given type
+	// and status, such as PodScheduled=False for unschedulable pods.  Each
+	// call adds a condition that must hold.  Pods without the condition
+	// are not matched.
+	WithCondition(condType v1.PodConditionType, status v1.ConditionStatus) DSBuilder
+
+	// WithNewerThan matches pods created within the given duration and
+	// WithOlderThan matches pods created at least the given duration ago.
+	// Age is evaluated when a pod is first seen and each time it changes;
+	// a pod is not dropped merely because it has aged out of the window.
+	WithNewerThan(d time.Duration) DSBuilder
+	WithOlderThan(d time.Duration) DSBuilder
+
+	// WithHostNetwork matches pods that use the host's network namespace,
+	// if enabled, or that do not otherwise.
+	WithHostNetwork(enabled bool) DSBuilder
+
+	// WithTerminating matches pods that are being deleted, if enabled, or
+	// that are not otherwise.  A pod is being deleted from when its
+	// deletion timestamp is set until it is gone.
+	WithTerminating(enabled bool) DSBuilder
+
+	// WithExcludeTerminated drops pods that have run to completion: those
+	// in the Succeeded or Failed phase.  They are matched by default.
+	WithExcludeTerminated() DSBuilder
+
+	// WithQoSClass matches pods of any of the given QoS classes.  Pods whose
+	// status does not yet report a QoS class are not matched.
+	WithQoSClass(classes ...v1.PodQOSClass) DSBuilder
+
+	// WithPriorityClass matches pods of any of the given priority classes.
+	// An empty name matches pods that do not name a priority class, which
+	// run at the global default priority.
+	WithPriorityClass(names ...string) DSBuilder
+
+	// WithPodCIDR matches pods whose IP is within any of the given CIDRs.
+	// Pods join the set once they are assigned an IP.  An error is
+	// returned, and the builder left unchanged, if any fails to parse.
+	WithPodCIDR(cidrs ...string) (DSBuilder, error)
+
+	// WithHostname matches pods with any of the given hostnames, such as
+	// "web-0", or hostnames qualified by subdomain, such as "web-0.web".
+	// A pod without spec.hostname has its name as hostname, as it would
+	// inside the pod.
+	WithHostname(names ...string) DSBuilder
+
+	// WithPodNodeSelectorKey matches pods whose spec.nodeSelector has any of
+	// the given keys.  Node affinity rules are not considered.
+	WithPodNodeSelectorKey(keys ...string) DSBuilder
+
+	// WithImageID matches pods with a container running any of the given
+	// image IDs, as resolved by the container runtime and reported in the
+	// container's status.  An ID matches if it is the reported ID or the
+	// part of it following an "@" or "://", so "sha256:<digest>" and
+	// "repo@sha256:<digest>" both match
+	// "docker-pullable://repo@sha256:<digest>", but a partial digest does
+	// not.
+	WithImageID(ids ...string) DSBuilder
+
+	// WithFilter matches pods accepted by all of the given filters.  Filters
+	// run client-side, together with the other criteria evaluated directly
+	// against pods and before those that join other resources, such as
+	// WithService.  They are evaluated whenever a pod changes and must not
+	// block.
+	WithFilter(filters ...filter.Filter) DSBuilder
+
+	// WithEvents enables watching cluster events involving matched pods,
+	// which are delivered by the datastore's KubeEvents channel.
+	WithEvents(enabled bool) DSBuilder
+
+	// WithJoin narrows the matched set with a caller-supplied join, which is
+	// given the pods matched so far and returns the pods to keep.  Joins run
+	// in the order given, after all built-in criteria, including the
+	// resource joins such as WithService, and before WithLimit; each is
+	// given the pods returned by the one before.
+	//
+	// The returned controller belongs to the datastore, which waits for it
+	// to be ready and closes it, along with its other controllers, when
+	// closed or rebuilt by Refresh or Reconfigure.  Any other controllers
+	// the join creates should be built with ctx, which is cancelled then.
+	//
+	// An error from a join, or a nil controller, aborts Create, which
+	// closes the controllers created so far and returns the error wrapped
+	// as "join <i>: <error>", where i is the join's position among those
+	// given.
+	WithJoin(fn func(ctx context.Context, pods pod.Controller) (pod.Controller, error)) DSBuilder
+
+	// WithLimit caps the matched pod set at n pods, preferring the most
+	// recently created.  When more than n pods match, the oldest are dropped
+	// and a warning is logged; dropped pods are added back as newer ones go
+	// away.  Zero means no limit.
+	WithLimit(n int) DSBuilder
+
+	// WithCreateRetry retries the creation of controllers that fails with a
+	// transient error, such as a refused connection or an unavailable API
+	// server, up to attempts times in total.  The delay between attempts
+	// starts at backoff and doubles each time.
+	WithCreateRetry(attempts int, backoff time.Duration) DSBuilder
+
+	// WithBestEffort ignores criteria whose controllers cannot be created,
+	// such as those for API groups the cluster does not serve, rather than
+	// failing Create.  A warning is logged and the datastore matches as if
+	// the criteria had not been given, except that an ignored
+	// WithNamespaceSelector or WithPDB matches no pods: they would
+	// otherwise widen the matched set to every namespace or pod.  Pod
+	// criteria are never ignored.
+	WithBestEffort() DSBuilder
+
+	// WithoutMissingWarnings disables the warning logged, once the
+	// datastore is ready, for each pod or other resource named in the
+	// criteria that does not exist.
+	WithoutMissingWarnings() DSBuilder
+
+	// WithIgnoreForbidden is WithBestEffort for criteria whose controllers
+	// the client is not authorized to list or watch only.  Other failures
+	// still fail Create.
+	WithIgnoreForbidden() DSBuilder
+
+	// WithShutdownGrace bounds how long a closed datastore waits for its
+	// controllers to complete.  Once the grace period expires, Done is
+	// closed regardless and the controllers still running are logged.
+	// Zero waits indefinitely.
+	WithShutdownGrace(d time.Duration) DSBuilder
+
+	// WithClientQPS sets the rate limits of the client built by
+	// CreateFromConfig.  It has no effect on clients passed to Create.
+	WithClientQPS(qps float32, burst int) DSBuilder
+
+	// WithLogger sets the logger used by created datastores in place of the
+	// one carried by the Create context.
+	WithLogger(log logutil.Log) DSBuilder
+
+	// WithMetrics reports datastore and log streaming measurements to m.
+	// Package prommetrics reports them to Prometheus.
+	WithMetrics(m Metrics) DSBuilder
+
+	// WithTracer traces Create, the construction of each controller and the
+	// wait for readiness using t.
+	WithTracer(t Tracer) DSBuilder
+
+	// WithClock sets the clock consulted by age criteria, retry and
+	// reconnect delays, and readiness timings.  The default is the real
+	// clock.
+	WithClock(clk clock.Clock) DSBuilder
+
+	// OnPodAdd and OnPodRemove register functions called as pods enter and
+	// leave the matched set, starting with an add for each pod in the
+	// initial set.  They are called from a single goroutine per datastore,
+	// separate from the one watching pods, so a slow handler delays only
+	// the handlers.  Changes made meanwhile are coalesced per pod: a pod
+	// that enters and leaves the set while the handlers are busy is not
+	// reported, and one replaced by a pod of the same name is reported as
+	// removed and then added.
+	OnPodAdd(fn func(*v1.Pod)) DSBuilder
+	OnPodRemove(fn func(*v1.Pod)) DSBuilder
+
+	// WithSince sets how far back log streams start when they are opened.
+	WithSince(since time.Duration) DSBuilder
+
+	// WithTail sets the number of existing lines displayed when a log stream
+	// is first opened.  Negative values display all available lines.
+	WithTail(lines int64) DSBuilder
+
+	WithTimestamps(enabled bool) DSBuilder
+
+	// WithPrevious displays the logs of a container's previous instance
+	// when it is restarted.
+	WithPrevious(enabled bool) DSBuilder
+
+	// WithOrderedOutput buffers log lines for the given window and emits
+	// them sorted by their kubelet timestamps.  This requires WithTimestamps;
+	// lines without timestamps are ordered by arrival.  Every line is delayed
+	// by up to twice the window.
+	WithOrderedOutput(window time.Duration) DSBuilder
+
+	// WithReconnectBackoff delays reopening a container's log stream, after
+	// it ends or the container restarts, by a random duration of up to
+	// initial.  The limit doubles with each consecutive reopen of the same
+	// stream, up to max.  By default streams are reopened immediately.
+	WithReconnectBackoff(initial, max time.Duration) DSBuilder
+
+	// WithMaxConcurrentStreams caps the number of container log streams
+	// open at once across the datastore's controllers.  Streams over the
+	// cap are queued until another closes, rather than dropped.  Streams
+	// are held open while following a container, so with more matched
+	// containers than the cap the rest are not shown until slots free up.
+	// Zero, the default, means no cap.
+	WithMaxConcurrentStreams(n int) DSBuilder
+
+	// WithContainerInclude restricts streaming to the named containers.
+	WithContainerInclude(names ...string) DSBuilder
+
+	// WithContainerExclude prevents the named containers from being streamed.
+	WithContainerExclude(names ...string) DSBuilder
+
+	// WithInitContainers streams the logs of init containers as well as
+	// those of regular containers.  Init containers honor WithSince and
+	// WithTail, and are streamed until they exit.
+	WithInitContainers(enabled bool) DSBuilder
+
+	Clone() DSBuilder
+
+	// Reset clears all criteria and log options, keeping only the client,
+	// logging, tracing, clock and lifecycle settings.  Like a new builder,
+	// a reset builder must be given namespaces, or WithAllNamespaces,
+	// before Create.
+	Reset() DSBuilder
+	Validate() error
+	String() string
+
+	// Equal reports whether other describes the same selection, regardless
+	// of the order in which criteria were given.  Filters and joins cannot
+	// be compared, so builders using WithFilter or WithJoin are never equal.
+	Equal(other DSBuilder) bool
+
+	// Hash returns a stable digest of the selection described by the
+	// builder.  Builders that are Equal have the same Hash; a builder using
+	// WithFilter or WithJoin has a Hash of its own.
+	Hash() string
+
+	// Create builds the datastore.  Cancelling ctx closes the datastore.
 	Create(ctx context.Context, cs kubernetes.Interface) (DS, error)
+	CreateFromConfig(ctx context.Context, rc *rest.Config) (DS, error)
+
+	// CreateMulti builds a datastore for each of the named clusters.
+	CreateMulti(ctx context.Context, clients map[string]kubernetes.Interface) (MultiDS, error)
 }
 
+// SystemNamespaces are the namespaces excluded by WithoutSystemNamespaces.
+var SystemNamespaces = []string{"kube-system", "kube-public", "kube-node-lease"}
+
+type podJoin func(ctx context.Context, pods pod.Controller) (pod.Controller, error)
+
 func NewDSBuilder() DSBuilder {
 	return &dsBuilder{}
 }
 
+// NewSharedDSBuilder returns a builder whose datastores filter the given
+// pod controller rather than creating their own.  The shared controller is
+// not closed when the datastores are.
+func NewSharedDSBuilder(base pod.Controller) DSBuilder {
+	return &dsBuilder{podBase: base}
+}
+
 type dsBuilder struct {
+	podBase pod.Controller
+	qps     float32
+	burst   int
+	log     logutil.Log
+	metrics Metrics
+	tracer  Tracer
+	clock   clock.Clock
+
+	retryAttempts int
+	retryBackoff  time.Duration
+	bestEffort    bool
+	quietMissing  bool
+	noForbidden   bool
+	grace         time.Duration
+
+	onPodAdd    []func(*v1.Pod)
+	onPodRemove []func(*v1.Pod)
+
 	ignore      []labels.Selector
 	selectors   []labels.Selector
 	pods        []nsname.NSName
 	namespaces  []string
 	services    []nsname.NSName
 	nodes       []string
+	nodeLabels  []labels.Selector
 	rcs         []nsname.NSName
 	rss         []nsname.NSName
 	dss         []nsname.NSName
 	deployments []nsname.NSName
+	dcs         []nsname.NSName
 	ingresses   []nsname.NSName
+
+	anySelectors    [][]labels.Selector
+	deploymentRevs  []nsname.NSName
+	pdbs            []nsname.NSName
+	labelKeys       []string
+	excludedNs      []string
+	nsSelector      labels.Selector
+	serviceTypes    []v1.ServiceType
+	nodeReady       *bool
+	filters         []filter.Filter
+	podGlobs        []string
+	podRegexps      []*regexp.Regexp
+	containerStates []string
+	minRestarts     *restartFilter
+	minReady        int
+	conditions      []conditionFilter
+	age             ageFilter
+	hostNetwork     *bool
+	terminating     *bool
+	noTerminated    bool
+	qosClasses      []v1.PodQOSClass
+	priorityClasses []string
+	podCIDRs        []*net.IPNet
+	hostnames       []string
+	nodeSelKeys     []string
+	imageIDs        []string
+
+	joins []podJoin
+
+	allNamespaces bool
+	foldNames     bool
+	limit         int
+	kubeEvents    bool
+
+	since      time.Duration
+	tail       int64
+	timestamps bool
+	previous   bool
+	ordered    time.Duration
+	backoff    reconnectBackoff
+	maxStreams int
+
+	containerInclude []string
+	containerExclude []string
+	initContainers   bool
+
+	// addedNs holds the namespaces added to a datastore's criteria with
+	// AddNamespace.  They widen namespaces and nsSelector.
+	addedNs []string
 }
 
 func (b *dsBuilder) WithIgnore(selector ...labels.Selector) DSBuilder {
@@ -62,13 +500,94 @@ func (b *dsBuilder) WithSelectors(selectors ...labels.Selector) DSBuilder {
 	return b
 }
 
+func (b *dsBuilder) WithLabel(key, value string) DSBuilder {
+	return b.WithSelectors(labels.SelectorFromSet(labels.Set{key: value}))
+}
+
+func (b *dsBuilder) WithLabelKey(keys ...string) DSBuilder {
+	b.labelKeys = append(b.labelKeys, keys...)
+	return b
+}
+
+func (b *dsBuilder) WithAnySelector(selectors ...labels.Selector) DSBuilder {
+	if len(selectors) > 0 {
+		b.anySelectors = append(b.anySelectors, append([]labels.Selector(nil), selectors...))
+	}
+	return b
+}
+
+const controllerRevisionHashLabel = "controller-revision-hash"
+
+func (b *dsBuilder) WithControllerRevision(hash ...string) DSBuilder {
+	selectors := make([]labels.Selector, 0, len(hash))
+	for _, h := range hash {
+		selectors = append(selectors,
+			labels.SelectorFromSet(labels.Set{controllerRevisionHashLabel: h}))
+	}
+	return b.WithAnySelector(selectors...)
+}
+
 func (b *dsBuilder) WithPods(id ...nsname.NSName) DSBuilder {
 	b.pods = append(b.pods, id...)
 	return b
 }
 
+func (b *dsBuilder) WithPodName(namespace string, names ...string) DSBuilder {
+	for _, name := range names {
+		b.pods = append(b.pods, nsname.New(namespace, name))
+	}
+	return b
+}
+
+func (b *dsBuilder) WithPodNameGlob(patterns ...string) DSBuilder {
+	b.podGlobs = append(b.podGlobs, patterns...)
+	return b
+}
+
+func (b *dsBuilder) WithPodNameRegex(exprs ...string) (DSBuilder, error) {
+	compiled := make([]*regexp.Regexp, 0, len(exprs))
+	for _, expr := range exprs {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return b, fmt.Errorf("invalid pod name expression '%v': %v", expr, err)
+		}
+		compiled = append(compiled, re)
+	}
+	b.podRegexps = append(b.podRegexps, compiled...)
+	return b, nil
+}
+
+func (b *dsBuilder) WithCaseInsensitiveNames() DSBuilder {
+	b.foldNames = true
+	return b
+}
+
 func (b *dsBuilder) WithNamespace(name ...string) DSBuilder {
 	b.namespaces = append(b.namespaces, name...)
+	if len(name) > 0 {
+		b.allNamespaces = false
+	}
+	return b
+}
+
+func (b *dsBuilder) WithNamespaceSelector(selector labels.Selector) DSBuilder {
+	b.nsSelector = selector
+	return b
+}
+
+func (b *dsBuilder) WithoutNamespace(name ...string) DSBuilder {
+	b.excludedNs = append(b.excludedNs, name...)
+	return b
+}
+
+func (b *dsBuilder) WithoutSystemNamespaces(extra ...string) DSBuilder {
+	b.WithoutNamespace(SystemNamespaces...)
+	return b.WithoutNamespace(extra...)
+}
+
+func (b *dsBuilder) WithAllNamespaces() DSBuilder {
+	b.namespaces = nil
+	b.allNamespaces = true
 	return b
 }
 
@@ -77,11 +596,26 @@ func (b *dsBuilder) WithService(id ...nsname.NSName) DSBuilder {
 	return b
 }
 
+func (b *dsBuilder) WithServiceType(types ...v1.ServiceType) DSBuilder {
+	b.serviceTypes = append(b.serviceTypes, types...)
+	return b
+}
+
 func (b *dsBuilder) WithNode(name ...string) DSBuilder {
 	b.nodes = append(b.nodes, name...)
 	return b
 }
 
+func (b *dsBuilder) WithNodeSelector(selectors ...labels.Selector) DSBuilder {
+	b.nodeLabels = append(b.nodeLabels, selectors...)
+	return b
+}
+
+func (b *dsBuilder) WithNodeReady(ready bool) DSBuilder {
+	b.nodeReady = &ready
+	return b
+}
+
 func (b *dsBuilder) WithRC(id ...nsname.NSName) DSBuilder {
 	b.rcs = append(b.rcs, id...)
 	return b
@@ -102,217 +636,1157 @@ func (b *dsBuilder) WithDeployment(id ...nsname.NSName) DSBuilder {
 	return b
 }
 
+func (b *dsBuilder) WithDeploymentAllRevisions(id ...nsname.NSName) DSBuilder {
+	b.deploymentRevs = append(b.deploymentRevs, id...)
+	return b
+}
+
+func (b *dsBuilder) WithDeploymentConfig(id ...nsname.NSName) DSBuilder {
+	b.dcs = append(b.dcs, id...)
+	return b
+}
+
 func (b *dsBuilder) WithIngress(id ...nsname.NSName) DSBuilder {
 	b.ingresses = append(b.ingresses, id...)
 	return b
 }
 
-func (b *dsBuilder) Create(ctx context.Context, cs kubernetes.Interface) (DS, error) {
-	log := logutil.FromContextOrDefault(ctx)
+func (b *dsBuilder) WithPDB(id ...nsname.NSName) DSBuilder {
+	b.pdbs = append(b.pdbs, id...)
+	return b
+}
 
-	ds := &datastore{
-		readych: make(chan struct{}),
-		donech:  make(chan struct{}),
-		log:     log.WithComponent("kail.ds"),
+func (b *dsBuilder) WithContainerState(reasons ...string) DSBuilder {
+	b.containerStates = append(b.containerStates, reasons...)
+	return b
+}
+
+func (b *dsBuilder) WithMinRestarts(n int32) DSBuilder {
+	b.minRestarts = &restartFilter{min: n}
+	return b
+}
+
+func (b *dsBuilder) WithMinContainerRestarts(n int32) DSBuilder {
+	b.minRestarts = &restartFilter{min: n, perContainer: true}
+	return b
+}
+
+func (b *dsBuilder) WithMinReadyContainers(n int) DSBuilder {
+	b.minReady = n
+	return b
+}
+
+func (b *dsBuilder) WithCondition(condType v1.PodConditionType, status v1.ConditionStatus) DSBuilder {
+	b.conditions = append(b.conditions, conditionFilter{condType, status})
+	return b
+}
+
+func (b *dsBuilder) WithNewerThan(d time.Duration) DSBuilder {
+	b.age.newerThan = d
+	return b
+}
+
+func (b *dsBuilder) WithOlderThan(d time.Duration) DSBuilder {
+	b.age.olderThan = d
+	return b
+}
+
+func (b *dsBuilder) WithHostNetwork(enabled bool) DSBuilder {
+	b.hostNetwork = &enabled
+	return b
+}
+
+func (b *dsBuilder) WithTerminating(enabled bool) DSBuilder {
+	b.terminating = &enabled
+	return b
+}
+
+func (b *dsBuilder) WithExcludeTerminated() DSBuilder {
+	b.noTerminated = true
+	return b
+}
+
+func (b *dsBuilder) WithQoSClass(classes ...v1.PodQOSClass) DSBuilder {
+	b.qosClasses = append(b.qosClasses, classes...)
+	return b
+}
+
+func (b *dsBuilder) WithPriorityClass(names ...string) DSBuilder {
+	b.priorityClasses = append(b.priorityClasses, names...)
+	return b
+}
+
+func (b *dsBuilder) WithPodCIDR(cidrs ...string) (DSBuilder, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return b, fmt.Errorf("invalid pod cidr '%v': %v", cidr, err)
+		}
+		nets = append(nets, ipnet)
 	}
+	b.podCIDRs = append(b.podCIDRs, nets...)
+	return b, nil
+}
 
-	log = log.WithComponent("kail.ds.builder")
+func (b *dsBuilder) WithHostname(names ...string) DSBuilder {
+	b.hostnames = append(b.hostnames, names...)
+	return b
+}
 
-	base, err := pod.NewController(ctx, log, cs, "")
-	if err != nil {
-		return nil, log.Err(err, "base pod controller")
+func (b *dsBuilder) WithPodNodeSelectorKey(keys ...string) DSBuilder {
+	b.nodeSelKeys = append(b.nodeSelKeys, keys...)
+	return b
+}
+
+func (b *dsBuilder) WithImageID(ids ...string) DSBuilder {
+	b.imageIDs = append(b.imageIDs, ids...)
+	return b
+}
+
+func (b *dsBuilder) WithFilter(filters ...filter.Filter) DSBuilder {
+	b.filters = append(b.filters, filters...)
+	return b
+}
+
+func (b *dsBuilder) WithEvents(enabled bool) DSBuilder {
+	b.kubeEvents = enabled
+	return b
+}
+
+func (b *dsBuilder) WithJoin(fn func(ctx context.Context, pods pod.Controller) (pod.Controller, error)) DSBuilder {
+	b.joins = append(b.joins, fn)
+	return b
+}
+
+func (b *dsBuilder) WithLimit(n int) DSBuilder {
+	b.limit = n
+	return b
+}
+
+func (b *dsBuilder) WithCreateRetry(attempts int, backoff time.Duration) DSBuilder {
+	b.retryAttempts = attempts
+	b.retryBackoff = backoff
+	return b
+}
+
+func (b *dsBuilder) WithBestEffort() DSBuilder {
+	b.bestEffort = true
+	return b
+}
+
+func (b *dsBuilder) WithoutMissingWarnings() DSBuilder {
+	b.quietMissing = true
+	return b
+}
+
+func (b *dsBuilder) WithIgnoreForbidden() DSBuilder {
+	b.noForbidden = true
+	return b
+}
+
+func (b *dsBuilder) WithShutdownGrace(d time.Duration) DSBuilder {
+	b.grace = d
+	return b
+}
+
+func (b *dsBuilder) WithClientQPS(qps float32, burst int) DSBuilder {
+	b.qps = qps
+	b.burst = burst
+	return b
+}
+
+func (b *dsBuilder) WithLogger(log logutil.Log) DSBuilder {
+	b.log = log
+	return b
+}
+
+func (b *dsBuilder) WithMetrics(m Metrics) DSBuilder {
+	b.metrics = m
+	return b
+}
+
+func (b *dsBuilder) WithTracer(t Tracer) DSBuilder {
+	b.tracer = t
+	return b
+}
+
+func (b *dsBuilder) WithClock(clk clock.Clock) DSBuilder {
+	b.clock = clk
+	return b
+}
+
+func (b *dsBuilder) OnPodAdd(fn func(*v1.Pod)) DSBuilder {
+	b.onPodAdd = append(b.onPodAdd, fn)
+	return b
+}
+
+func (b *dsBuilder) OnPodRemove(fn func(*v1.Pod)) DSBuilder {
+	b.onPodRemove = append(b.onPodRemove, fn)
+	return b
+}
+
+func (b *dsBuilder) WithSince(since time.Duration) DSBuilder {
+	b.since = since
+	return b
+}
+
+func (b *dsBuilder) WithTail(lines int64) DSBuilder {
+	b.tail = lines
+	return b
+}
+
+func (b *dsBuilder) WithTimestamps(enabled bool) DSBuilder {
+	b.timestamps = enabled
+	return b
+}
+
+func (b *dsBuilder) WithPrevious(enabled bool) DSBuilder {
+	b.previous = enabled
+	return b
+}
+
+func (b *dsBuilder) WithOrderedOutput(window time.Duration) DSBuilder {
+	b.ordered = window
+	return b
+}
+
+func (b *dsBuilder) WithReconnectBackoff(initial, max time.Duration) DSBuilder {
+	b.backoff = reconnectBackoff{initial, max}
+	return b
+}
+
+func (b *dsBuilder) WithMaxConcurrentStreams(n int) DSBuilder {
+	b.maxStreams = n
+	return b
+}
+
+func (b *dsBuilder) WithContainerInclude(names ...string) DSBuilder {
+	b.containerInclude = append(b.containerInclude, names...)
+	return b
+}
+
+func (b *dsBuilder) WithContainerExclude(names ...string) DSBuilder {
+	b.containerExclude = append(b.containerExclude, names...)
+	return b
+}
+
+func (b *dsBuilder) WithInitContainers(enabled bool) DSBuilder {
+	b.initContainers = enabled
+	return b
+}
+
+func (b *dsBuilder) Clone() DSBuilder {
+	return &dsBuilder{
+		podBase:     b.podBase,
+		qps:         b.qps,
+		burst:       b.burst,
+		log:         b.log,
+		metrics:     b.metrics,
+		tracer:      b.tracer,
+		clock:       b.clock,
+		onPodAdd:    append(([]func(*v1.Pod))(nil), b.onPodAdd...),
+		onPodRemove: append(([]func(*v1.Pod))(nil), b.onPodRemove...),
+		ignore:      append([]labels.Selector(nil), b.ignore...),
+		selectors:   append([]labels.Selector(nil), b.selectors...),
+		pods:        append([]nsname.NSName(nil), b.pods...),
+		namespaces:  append([]string(nil), b.namespaces...),
+		services:    append([]nsname.NSName(nil), b.services...),
+		nodes:       append([]string(nil), b.nodes...),
+		nodeLabels:  append([]labels.Selector(nil), b.nodeLabels...),
+		rcs:         append([]nsname.NSName(nil), b.rcs...),
+		rss:         append([]nsname.NSName(nil), b.rss...),
+		dss:         append([]nsname.NSName(nil), b.dss...),
+		deployments: append([]nsname.NSName(nil), b.deployments...),
+		dcs:         append([]nsname.NSName(nil), b.dcs...),
+		ingresses:   append([]nsname.NSName(nil), b.ingresses...),
+		limit:       b.limit,
+		kubeEvents:  b.kubeEvents,
+		joins:       append([]podJoin(nil), b.joins...),
+		since:       b.since,
+		tail:        b.tail,
+		timestamps:  b.timestamps,
+		previous:    b.previous,
+		ordered:     b.ordered,
+		backoff:     b.backoff,
+		maxStreams:  b.maxStreams,
+
+		containerStates: append([]string(nil), b.containerStates...),
+		minRestarts:     b.minRestarts,
+		minReady:        b.minReady,
+		conditions:      append([]conditionFilter(nil), b.conditions...),
+		age:             b.age,
+		hostNetwork:     b.hostNetwork,
+		terminating:     b.terminating,
+		noTerminated:    b.noTerminated,
+		qosClasses:      append([]v1.PodQOSClass(nil), b.qosClasses...),
+		priorityClasses: append([]string(nil), b.priorityClasses...),
+		podCIDRs:        append([]*net.IPNet(nil), b.podCIDRs...),
+		hostnames:       append([]string(nil), b.hostnames...),
+		nodeSelKeys:     append([]string(nil), b.nodeSelKeys...),
+		imageIDs:        append([]string(nil), b.imageIDs...),
+		deploymentRevs:  append([]nsname.NSName(nil), b.deploymentRevs...),
+		pdbs:            append([]nsname.NSName(nil), b.pdbs...),
+		allNamespaces:   b.allNamespaces,
+		foldNames:       b.foldNames,
+
+		containerInclude: append([]string(nil), b.containerInclude...),
+		containerExclude: append([]string(nil), b.containerExclude...),
+		initContainers:   b.initContainers,
+
+		anySelectors: append([][]labels.Selector(nil), b.anySelectors...),
+		labelKeys:    append([]string(nil), b.labelKeys...),
+		excludedNs:   append([]string(nil), b.excludedNs...),
+		addedNs:      append([]string(nil), b.addedNs...),
+		nsSelector:   b.nsSelector,
+		serviceTypes: append([]v1.ServiceType(nil), b.serviceTypes...),
+		nodeReady:    b.nodeReady,
+		filters:      append([]filter.Filter(nil), b.filters...),
+		podGlobs:     append([]string(nil), b.podGlobs...),
+		podRegexps:   append([]*regexp.Regexp(nil), b.podRegexps...),
+
+		retryAttempts: b.retryAttempts,
+		retryBackoff:  b.retryBackoff,
+		bestEffort:    b.bestEffort,
+		quietMissing:  b.quietMissing,
+		noForbidden:   b.noForbidden,
+		grace:         b.grace,
 	}
+}
 
-	ds.podBase = base
-	ds.pods, err = base.CloneWithFilter(filter.Null())
-	if err != nil {
-		ds.closeAll()
-		return nil, log.Err(err, "null filter")
+func (b *dsBuilder) Reset() DSBuilder {
+	*b = dsBuilder{
+		podBase: b.podBase,
+		qps:     b.qps,
+		burst:   b.burst,
+		log:     b.log,
+		metrics: b.metrics,
+		tracer:  b.tracer,
+		clock:   b.clock,
+
+		retryAttempts: b.retryAttempts,
+		retryBackoff:  b.retryBackoff,
+		bestEffort:    b.bestEffort,
+		quietMissing:  b.quietMissing,
+		noForbidden:   b.noForbidden,
+		grace:         b.grace,
 	}
+	return b
+}
 
-	if len(b.ignore) != 0 {
-		filters := make([]filter.Filter, 0, len(b.ignore))
-		for _, selector := range b.ignore {
-			filters = append(filters, filter.Not(filter.Selector(selector)))
+func (b *dsBuilder) Validate() error {
+	if err := validateSelectors("ignore", b.ignore); err != nil {
+		return err
+	}
+	if err := validateSelectors("selector", b.selectors); err != nil {
+		return err
+	}
+	for _, selectors := range b.anySelectors {
+		if err := validateSelectors("selector", selectors); err != nil {
+			return err
 		}
-		ds.pods, err = ds.pods.CloneWithFilter(filter.And(filters...))
-		if err != nil {
-			ds.closeAll()
-			return nil, log.Err(err, "labels filter")
+	}
+	if _, err := labelKeySelector(b.labelKeys); err != nil {
+		return err
+	}
+	if err := validateSelectors("node selector", b.nodeLabels); err != nil {
+		return err
+	}
+	if err := validateNames("namespace", b.namespaces); err != nil {
+		return err
+	}
+	if err := validateNames("excluded namespace", b.excludedNs); err != nil {
+		return err
+	}
+	if err := validateNames("node", b.nodes); err != nil {
+		return err
+	}
+	if err := validateNames("container state", b.containerStates); err != nil {
+		return err
+	}
+	if err := validateNames("hostname", b.hostnames); err != nil {
+		return err
+	}
+	if err := validateNames("node selector key", b.nodeSelKeys); err != nil {
+		return err
+	}
+	if err := validateNames("image id", b.imageIDs); err != nil {
+		return err
+	}
+	for _, c := range b.conditions {
+		if c.condType == "" {
+			return fmt.Errorf("invalid condition: empty type")
 		}
 	}
 
-	if len(b.selectors) != 0 {
-		filters := make([]filter.Filter, 0, len(b.selectors))
-		for _, selector := range b.selectors {
-			filters = append(filters, filter.Selector(selector))
+	ids := []struct {
+		name string
+		ids  []nsname.NSName
+	}{
+		{"pod", b.pods},
+		{"service", b.services},
+		{"rc", b.rcs},
+		{"rs", b.rss},
+		{"ds", b.dss},
+		{"deployment", b.deployments},
+		{"deployment revisions", b.deploymentRevs},
+		{"deployment config", b.dcs},
+		{"ingress", b.ingresses},
+		{"pdb", b.pdbs},
+	}
+	for _, v := range ids {
+		if err := validateIds(v.name, v.ids); err != nil {
+			return err
 		}
-		ds.pods, err = ds.pods.CloneWithFilter(filter.And(filters...))
-		if err != nil {
-			ds.closeAll()
-			return nil, log.Err(err, "labels filter")
+	}
+	if len(b.namespaces) == 0 && !b.allNamespaces && b.nsSelector == nil && !b.podsNameNamespaces() {
+		return fmt.Errorf("no namespace given: use WithNamespace, or WithAllNamespaces to watch the entire cluster")
+	}
+	if b.minRestarts != nil && b.minRestarts.min < 0 {
+		return fmt.Errorf("invalid minimum restarts %v: must not be negative", b.minRestarts.min)
+	}
+	if b.age.newerThan < 0 || b.age.olderThan < 0 {
+		return fmt.Errorf("invalid age: must not be negative")
+	}
+	if b.age.newerThan > 0 && b.age.olderThan >= b.age.newerThan {
+		return fmt.Errorf("invalid age: older than %v and newer than %v matches nothing",
+			b.age.olderThan, b.age.newerThan)
+	}
+	for _, pattern := range b.podGlobs {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pod name pattern '%v': %v", pattern, err)
+		}
+	}
+	for _, fn := range b.joins {
+		if fn == nil {
+			return fmt.Errorf("invalid join: nil function")
+		}
+	}
+	for _, f := range b.filters {
+		if f == nil {
+			return fmt.Errorf("invalid filter: nil filter")
 		}
 	}
+	if b.limit < 0 {
+		return fmt.Errorf("invalid limit %v: must not be negative", b.limit)
+	}
+	if b.maxStreams < 0 {
+		return fmt.Errorf("invalid max concurrent streams %v: must not be negative", b.maxStreams)
+	}
+	return nil
+}
 
-	if len(b.pods) != 0 {
-		ds.pods, err = ds.pods.CloneWithFilter(filter.NSName(b.pods...))
-		if err != nil {
-			ds.closeAll()
-			return nil, log.Err(err, "pods filter")
+func (b *dsBuilder) String() string {
+	var parts []string
+
+	parts = appendSelectors(parts, "ignore", b.ignore)
+	parts = appendSelectors(parts, "selectors", b.selectors)
+	parts = appendNames(parts, "label-keys", b.labelKeys)
+	for _, selectors := range b.anySelectors {
+		parts = append(parts, fmt.Sprintf("any-selectors=[%v]", selectorsString(selectors)))
+	}
+	parts = appendIds(parts, "pods", b.pods)
+	parts = appendNames(parts, "pod-globs", b.podGlobs)
+	if len(b.podRegexps) != 0 {
+		vals := make([]string, 0, len(b.podRegexps))
+		for _, re := range b.podRegexps {
+			vals = append(vals, re.String())
+		}
+		parts = appendNames(parts, "pod-regexps", vals)
+	}
+	if b.foldNames {
+		parts = append(parts, "case-insensitive-names")
+	}
+	parts = appendNames(parts, "namespaces", b.namespaces)
+	if b.allNamespaces {
+		parts = append(parts, "namespaces=*")
+	}
+	parts = appendNames(parts, "excluded-namespaces", b.excludedNs)
+	if b.nsSelector != nil {
+		parts = append(parts, fmt.Sprintf("namespace-selector=[%v]", b.nsSelector))
+	}
+	parts = appendIds(parts, "services", b.services)
+	if len(b.serviceTypes) != 0 {
+		vals := make([]string, 0, len(b.serviceTypes))
+		for _, t := range b.serviceTypes {
+			vals = append(vals, string(t))
 		}
+		parts = appendNames(parts, "service-types", vals)
 	}
+	parts = appendNames(parts, "nodes", b.nodes)
+	parts = appendSelectors(parts, "node-selectors", b.nodeLabels)
+	if b.nodeReady != nil {
+		parts = append(parts, fmt.Sprintf("node-ready=%v", *b.nodeReady))
+	}
+	parts = appendIds(parts, "rcs", b.rcs)
+	parts = appendIds(parts, "rss", b.rss)
+	parts = appendIds(parts, "dss", b.dss)
+	parts = appendIds(parts, "deployments", b.deployments)
+	parts = appendIds(parts, "deployment-revisions", b.deploymentRevs)
+	parts = appendIds(parts, "dcs", b.dcs)
+	parts = appendIds(parts, "ingresses", b.ingresses)
+	parts = appendIds(parts, "pdbs", b.pdbs)
+	parts = appendNames(parts, "container-states", b.containerStates)
 
-	if sz := len(b.namespaces); sz > 0 {
-		ids := make([]nsname.NSName, 0, sz)
-		for _, ns := range b.namespaces {
-			ids = append(ids, nsname.New(ns, ""))
+	if f := b.minRestarts; f != nil {
+		if f.perContainer {
+			parts = append(parts, fmt.Sprintf("min-container-restarts=%v", f.min))
+		} else {
+			parts = append(parts, fmt.Sprintf("min-restarts=%v", f.min))
 		}
+	}
 
-		ds.pods, err = ds.pods.CloneWithFilter(filter.NSName(ids...))
-		if err != nil {
-			ds.closeAll()
-			return nil, log.Err(err, "namespace filter")
+	if b.minReady > 0 {
+		parts = append(parts, fmt.Sprintf("min-ready-containers=%v", b.minReady))
+	}
+	if len(b.conditions) != 0 {
+		vals := make([]string, 0, len(b.conditions))
+		for _, c := range b.conditions {
+			vals = append(vals, fmt.Sprintf("%v=%v", c.condType, c.status))
 		}
+		parts = appendNames(parts, "conditions", vals)
 	}
 
-	if len(b.nodes) != 0 {
-		ds.pods, err = ds.pods.CloneWithFilter(pod.NodeFilter(b.nodes...))
-		if err != nil {
-			ds.closeAll()
-			return nil, log.Err(err, "node filter")
+	if b.age.newerThan > 0 {
+		parts = append(parts, fmt.Sprintf("newer-than=%v", b.age.newerThan))
+	}
+	if b.age.olderThan > 0 {
+		parts = append(parts, fmt.Sprintf("older-than=%v", b.age.olderThan))
+	}
+
+	if b.hostNetwork != nil {
+		parts = append(parts, fmt.Sprintf("host-network=%v", *b.hostNetwork))
+	}
+	if b.terminating != nil {
+		parts = append(parts, fmt.Sprintf("terminating=%v", *b.terminating))
+	}
+	if b.noTerminated {
+		parts = append(parts, "exclude-terminated")
+	}
+	if len(b.qosClasses) != 0 {
+		vals := make([]string, 0, len(b.qosClasses))
+		for _, class := range b.qosClasses {
+			vals = append(vals, string(class))
+		}
+		parts = appendNames(parts, "qos-classes", vals)
+	}
+	parts = appendNames(parts, "priority-classes", b.priorityClasses)
+	if len(b.podCIDRs) != 0 {
+		vals := make([]string, 0, len(b.podCIDRs))
+		for _, ipnet := range b.podCIDRs {
+			vals = append(vals, ipnet.String())
 		}
+		parts = appendNames(parts, "pod-cidrs", vals)
+	}
+	parts = appendNames(parts, "hostnames", b.hostnames)
+	parts = appendNames(parts, "pod-node-selector-keys", b.nodeSelKeys)
+	parts = appendNames(parts, "image-ids", b.imageIDs)
+
+	if b.limit > 0 {
+		parts = append(parts, fmt.Sprintf("limit=%v", b.limit))
+	}
+
+	return strings.Join(parts, " ")
+}
+
+func (b *dsBuilder) Create(ctx context.Context, cs kubernetes.Interface) (_ DS, err error) {
+	log := b.logger(ctx)
+	ctx = logutil.NewContext(ctx, log)
+
+	tracer := b.tracer
+	if tracer == nil {
+		tracer = nullTracer{}
+	}
+
+	ctx, span := tracer.Start(ctx, "kail.ds.create")
+	defer func() { endSpan(span, err) }()
+
+	if err := b.Validate(); err != nil {
+		return nil, log.Err(err, "invalid criteria")
+	}
+	b = b.normalize()
+
+	ds := &datastore{
+		feeds:      make(map[*podFeed]bool),
+		tracer:     tracer,
+		cs:         cs,
+		clock:      b.clk(),
+		created:    b.clk().Now(),
+		readych:    make(chan struct{}),
+		donech:     make(chan struct{}),
+		closech:    make(chan struct{}),
+		grace:      b.grace,
+		since:      b.since,
+		tail:       b.tail,
+		timestamps: b.timestamps,
+		previous:   b.previous,
+		ordered:    b.ordered,
+		backoff:    b.backoff,
+		streams:    newStreamLimiter(b.maxStreams),
+		metrics:    b.metrics,
+
+		onPodAdd:    b.onPodAdd,
+		onPodRemove: b.onPodRemove,
+
+		containerInclude: b.containerInclude,
+		containerExclude: b.containerExclude,
+		initContainers:   b.initContainers,
+
+		log: log.WithComponent("kail.ds"),
 	}
 
-	if len(b.services) != 0 {
-		ds.servicesBase, err = service.NewController(ctx, log, cs, "")
+	ds.quietMissing = b.quietMissing
+	ds.ctx, ds.cancel = context.WithCancel(ctx)
+
+	ds.current, err = b.createChain(ds.ctx, cs, tracer, log)
+	if err != nil {
+		ds.cancel()
+		return nil, err
+	}
+
+	if ds.metrics == nil {
+		ds.metrics = nullMetrics{}
+	}
+	ds.metrics = &statsMetrics{ds.metrics, ds}
+
+	if b.metrics != nil || len(b.onPodAdd) > 0 || len(b.onPodRemove) > 0 {
+		ds.podsub, err = ds.subscribePods()
 		if err != nil {
-			ds.closeAll()
-			return nil, log.Err(err, "service base controller")
+			ds.abort()
+			return nil, ds.log.Err(err, "pod subscription")
 		}
+	}
 
-		ds.services, err = ds.servicesBase.CloneWithFilter(filter.NSName(b.services...))
+	if b.kubeEvents {
+		ds.kubeEventsch = make(chan *v1.Event, kubeEventBufsiz)
+		go ds.watchKubeEvents(cs)
+	}
+
+	_, ds.readySpan = tracer.Start(ctx, "kail.ds.ready")
+
+	ds.run(ctx)
+
+	return ds, nil
+}
+
+// createChain builds the controllers computing the pods matched by b.  On
+// failure every controller created is closed.
+func (b *dsBuilder) createChain(
+	ctx context.Context, cs kubernetes.Interface, tracer Tracer, log logutil.Log) (_ *dsChain, err error) {
+
+	c := newDSChain(log.WithComponent("kail.ds"))
+	log = log.WithComponent("kail.ds.builder")
+
+	c.podBase = b.podBase
+	c.podBaseShared = b.podBase != nil
+
+	if !c.podBaseShared {
+		c.podNamespace = b.podNamespace()
+		sctx, span := startSpan(ctx, tracer, "kail.ds.base", "pod")
+		err = b.retry(sctx, log, "base pod controller", func() (err error) {
+			c.podBase, err = b.newPodBase(ctx, log, cs, c.podNamespace)
+			return err
+		})
+		endSpan(span, err)
 		if err != nil {
-			ds.closeAll()
-			return nil, log.Err(err, "service controller")
+			return nil, log.Err(err, "base pod controller")
 		}
+	}
 
-		ds.pods, err = join.ServicePods(ctx, ds.services, ds.pods)
-		if err != nil {
-			ds.closeAll()
-			return nil, log.Err(err, "service join")
+	c.criteriaBuilder = b.Clone().(*dsBuilder)
+
+	c.criteria, err = c.podBase.CloneWithFilter(b.podFilter())
+	if err != nil {
+		c.abort()
+		return nil, log.Err(err, "pod filter")
+	}
+	c.setPods(c.criteria)
+
+	stages := []struct {
+		name   string
+		active bool
+		base   stageBaseFn
+		create stageFn
+	}{
+		{"node selector", len(b.nodeLabels) != 0 || b.nodeReady != nil, b.createNodeBase, b.createNodeStage},
+		{"service", len(b.services) != 0 || len(b.serviceTypes) != 0, b.createServiceBase, b.createServiceStage},
+		{"rc", len(b.rcs) != 0, b.createRCBase, b.createRCStage},
+		{"rs", len(b.rss) != 0, b.createRSBase, b.createRSStage},
+		{"ds", len(b.dss) != 0, b.createDSBase, b.createDSStage},
+		{"deployment", len(b.deployments) != 0, b.createDeploymentBase, b.createDeploymentStage},
+		{"deployment revisions", len(b.deploymentRevs) != 0, b.createRevisionsBase, b.createRevisionsStage},
+		{"deployment config", len(b.dcs) != 0, b.createDCBase, b.createDCStage},
+		{"ingress", len(b.ingresses) != 0, b.createIngressBase, b.createIngressStage},
+	}
+
+	// base controllers each perform an initial list; create them
+	// concurrently so that startup takes as long as the slowest.
+	bases := &stageBases{}
+	errs := make([]error, len(stages))
+
+	var wg sync.WaitGroup
+	for i, stage := range stages {
+		if !stage.active {
+			continue
 		}
+		wg.Add(1)
+		go func(i int, name string, fn stageBaseFn) {
+			defer wg.Done()
+			ctx, span := startSpan(ctx, tracer, "kail.ds.base", name)
+			errs[i] = fn(ctx, log, cs, bases)
+			endSpan(span, errs[i])
+		}(i, stage.name, stage.base)
 	}
+	wg.Wait()
 
-	if len(b.rcs) != 0 {
-		ds.rcsBase, err = replicationcontroller.NewController(ctx, log, cs, "")
+	for i, stage := range stages {
+		if !stage.active {
+			continue
+		}
+		err := errs[i]
+		forbidden := err != nil && errors.IsForbidden(err)
 		if err != nil {
-			ds.closeAll()
-			return nil, log.Err(err, "rc base controller")
+			err = fmt.Errorf("%v base controller: %v", stage.name, err)
+		} else {
+			sctx, span := startSpan(ctx, tracer, "kail.ds.stage", stage.name)
+			err = stage.create(sctx, c, bases)
+			endSpan(span, err)
 		}
-
-		ds.rcs, err = ds.rcsBase.CloneWithFilter(filter.NSName(b.rcs...))
 		if err != nil {
-			ds.closeAll()
-			return nil, log.Err(err, "rc controller")
+			if !b.bestEffort && !(forbidden && b.noForbidden) {
+				bases.close()
+				c.abort()
+				return nil, log.Err(err, "%v criteria", stage.name)
+			}
+			log.ErrWarn(err, "ignoring %v criteria", stage.name)
 		}
+	}
 
-		ds.pods, err = join.RCPods(ctx, ds.rcs, ds.pods)
+	if b.nsSelector != nil {
+		var changes <-chan struct{}
+		var compute func() (filter.Filter, error)
+
+		w, err := newNamespaceWatcher(ctx, b, cs, c.closech, c.log)
+		switch {
+		case err != nil && !b.bestEffort && !(errors.IsForbidden(err) && b.noForbidden):
+			c.abort()
+			return nil, log.Err(err, "namespace selector criteria")
+		case err != nil:
+			// the selected namespaces are unknown, and ignoring criteria
+			// must not widen the matched set: match only the namespaces
+			// added with AddNamespace.
+			log.ErrWarn(err, "ignoring namespace selector criteria: matching no selected namespaces")
+			changes = c.staticChanges()
+			compute = func() (filter.Filter, error) {
+				return namespaceFilter(c.addedNamespaces()), nil
+			}
+		default:
+			changes = w.changes
+			compute = func() (filter.Filter, error) {
+				return w.filter(c.addedNamespaces())
+			}
+		}
+
+		pods, err := c.pods.CloneWithFilter(newIDFilter(nil))
 		if err != nil {
-			ds.closeAll()
-			return nil, log.Err(err, "rc join")
+			c.abort()
+			return nil, log.Err(err, "namespace selector controller")
 		}
+		c.setPods(pods)
+
+		c.nsStage = c.addStage("namespace selector", pods, changes, compute)
 	}
 
-	if len(b.rss) != 0 {
-		ds.rssBase, err = replicaset.NewController(ctx, log, cs, "")
-		if err != nil {
-			ds.closeAll()
-			return nil, log.Err(err, "rs base controller")
+	if len(b.pdbs) != 0 {
+		var changes <-chan struct{}
+		var compute func() (filter.Filter, error)
+
+		w, err := newPDBWatcher(ctx, b, cs, c.closech, c.log)
+		switch {
+		case err != nil && !b.bestEffort && !(errors.IsForbidden(err) && b.noForbidden):
+			c.abort()
+			return nil, log.Err(err, "pdb criteria")
+		case err != nil:
+			// ignoring criteria must not widen the matched set.
+			log.ErrWarn(err, "ignoring pdb criteria: matching no pods")
+			changes = c.staticChanges()
+			compute = func() (filter.Filter, error) {
+				return newIDFilter(nil), nil
+			}
+		default:
+			changes, compute = w.changes, w.filter
 		}
 
-		ds.rss, err = ds.rssBase.CloneWithFilter(filter.NSName(b.rss...))
+		pods, err := c.pods.CloneWithFilter(newIDFilter(nil))
 		if err != nil {
-			ds.closeAll()
-			return nil, log.Err(err, "rs controller")
+			c.abort()
+			return nil, log.Err(err, "pdb controller")
 		}
+		c.setPods(pods)
 
-		ds.pods, err = join.RSPods(ctx, ds.rss, ds.pods)
-		if err != nil {
-			ds.closeAll()
-			return nil, log.Err(err, "rs join")
+		c.addStage("pdb", pods, changes, compute)
+	}
+
+	if len(b.joins) != 0 {
+		// controllers a join creates beyond the one it returns are stopped
+		// by cancelling its context when the chain is closed.
+		jctx, cancel := context.WithCancel(ctx)
+		go func() {
+			<-c.closech
+			cancel()
+		}()
+
+		for i, fn := range b.joins {
+			pods, err := fn(jctx, c.pods)
+			if err == nil && pods == nil {
+				err = fmt.Errorf("no pod controller returned")
+			}
+			if err != nil {
+				c.abort()
+				return nil, log.Err(fmt.Errorf("join %v: %v", i, err), "join criteria")
+			}
+			c.setPods(pods)
 		}
 	}
 
-	if len(b.dss) != 0 {
-		ds.dssBase, err = daemonset.NewController(ctx, log, cs, "")
+	if b.limit > 0 {
+		sub, err := c.pods.Subscribe()
 		if err != nil {
-			ds.closeAll()
-			return nil, log.Err(err, "ds base controller")
+			c.abort()
+			return nil, log.Err(err, "limit subscription")
 		}
 
-		ds.dss, err = ds.dssBase.CloneWithFilter(filter.NSName(b.dss...))
+		limited, err := c.pods.CloneWithFilter(newIDFilter(nil))
 		if err != nil {
-			ds.closeAll()
-			return nil, log.Err(err, "ds controller")
+			sub.Close()
+			c.abort()
+			return nil, log.Err(err, "limit controller")
 		}
+		c.setPods(limited)
 
-		ds.pods, err = join.DaemonSetPods(ctx, ds.dss, ds.pods)
-		if err != nil {
-			ds.closeAll()
-			return nil, log.Err(err, "ds join")
+		limiter := &podLimiter{limit: b.limit, sub: sub, log: c.log}
+		c.addStage("limit", limited, podChanges(sub), limiter.filter)
+	}
+
+	return c, nil
+}
+
+// podsNameNamespaces reports whether pods are selected by id, each of which
+// names the namespace it is in.
+func (b *dsBuilder) podsNameNamespaces() bool {
+	if len(b.pods) == 0 {
+		return false
+	}
+	for _, id := range b.pods {
+		if id.Namespace == "" {
+			return false
 		}
 	}
+	return true
+}
 
-	if len(b.deployments) != 0 {
-		ds.deploymentsBase, err = deployment.NewController(ctx, log, cs, "")
-		if err != nil {
-			ds.closeAll()
-			return nil, log.Err(err, "deployment base controller")
+func validateSelectors(name string, selectors []labels.Selector) error {
+	for _, selector := range selectors {
+		if selector == nil {
+			return fmt.Errorf("invalid %v: nil selector", name)
 		}
+	}
+	return nil
+}
 
-		ds.deployments, err = ds.deploymentsBase.CloneWithFilter(filter.NSName(b.deployments...))
-		if err != nil {
-			ds.closeAll()
-			return nil, log.Err(err, "deployment controller")
+func validateNames(name string, vals []string) error {
+	for _, val := range vals {
+		if val == "" {
+			return fmt.Errorf("invalid %v: empty name", name)
 		}
+	}
+	return nil
+}
 
-		ds.pods, err = join.DeploymentPods(ctx, ds.deployments, ds.pods)
-		if err != nil {
-			ds.closeAll()
-			return nil, log.Err(err, "deployment join")
+func validateIds(name string, ids []nsname.NSName) error {
+	for _, id := range ids {
+		if id.Name == "" {
+			return fmt.Errorf("invalid %v '%v/%v': empty name", name, id.Namespace, id.Name)
 		}
 	}
+	return nil
+}
+
+func (b *dsBuilder) CreateFromConfig(ctx context.Context, rc *rest.Config) (DS, error) {
+	config := *rc
+	if b.qps > 0 {
+		config.QPS = b.qps
+	}
+	if b.burst > 0 {
+		config.Burst = b.burst
+	}
 
-	if len(b.ingresses) != 0 {
-		ds.ingressesBase, err = ingress.NewController(ctx, log, cs, "")
-		if err != nil {
-			ds.closeAll()
-			return nil, log.Err(err, "ingress base controller")
+	cs, err := kubernetes.NewForConfig(&config)
+	if err != nil {
+		log := b.logger(ctx).WithComponent("kail.ds.builder")
+		return nil, log.Err(err, "kubernetes client")
+	}
+	return b.Create(ctx, cs)
+}
+
+// clk returns the clock set with WithClock, falling back to the real clock.
+func (b *dsBuilder) clk() clock.Clock {
+	if b.clock != nil {
+		return b.clock
+	}
+	return clock.RealClock{}
+}
+
+// logger returns the logger set with WithLogger, falling back to the one
+// carried by ctx.
+func (b *dsBuilder) logger(ctx context.Context) logutil.Log {
+	if b.log != nil {
+		return b.log
+	}
+	return logutil.FromContextOrDefault(ctx)
+}
+
+// podFilter combines the criteria that are evaluated directly against pods
+// into a single filter so that they require only one filtered controller.
+func (b *dsBuilder) podFilter() filter.Filter {
+	filters := b.podFilters()
+	if len(filters) == 0 {
+		return filter.Null()
+	}
+	return filter.And(filters...)
+}
+
+// podFilters returns the filters evaluated directly against pods, each of
+// which every matched pod must pass.
+func (b *dsBuilder) podFilters() []filter.Filter {
+	var filters []filter.Filter
+
+	for _, selector := range b.ignore {
+		filters = append(filters, filter.Not(filter.Selector(selector)))
+	}
+
+	for _, selector := range b.selectors {
+		filters = append(filters, filter.Selector(selector))
+	}
+
+	if len(b.labelKeys) != 0 {
+		// keys are checked by Validate.
+		selector, _ := labelKeySelector(b.labelKeys)
+		filters = append(filters, filter.Selector(selector))
+	}
+
+	for _, selectors := range b.anySelectors {
+		if len(selectors) == 1 {
+			filters = append(filters, filter.Selector(selectors[0]))
+			continue
 		}
+		alternatives := make([]filter.Filter, 0, len(selectors))
+		for _, selector := range selectors {
+			alternatives = append(alternatives, filter.Selector(selector))
+		}
+		filters = append(filters, filter.Or(alternatives...))
+	}
 
-		if ds.servicesBase == nil {
-			ds.servicesBase, err = service.NewController(ctx, log, cs, "")
-			if err != nil {
-				ds.closeAll()
-				return nil, log.Err(err, "service base controller")
+	if sz := len(b.pods); sz > 0 {
+		ids := make([]nsname.NSName, 0, sz)
+		for _, id := range b.pods {
+			ids = append(ids, nsname.New(b.foldName(id.Namespace), b.foldName(id.Name)))
+		}
+		filters = append(filters, filter.NSName(ids...))
+	}
+
+	if sz := len(b.podGlobs); sz > 0 {
+		patterns := make([]string, 0, sz)
+		for _, pattern := range b.podGlobs {
+			patterns = append(patterns, b.foldName(pattern))
+		}
+		filters = append(filters, nameGlobFilter(patterns))
+	}
+
+	if sz := len(b.podRegexps); sz > 0 {
+		exprs := b.podRegexps
+		if b.foldNames {
+			exprs = make([]*regexp.Regexp, 0, sz)
+			for _, re := range b.podRegexps {
+				// re has already compiled; so will its case-insensitive form.
+				exprs = append(exprs, regexp.MustCompile("(?i)"+re.String()))
 			}
-			ds.services = ds.servicesBase
 		}
+		filters = append(filters, nameRegexFilter(exprs))
+	}
 
-		ds.ingresses, err = ds.ingressesBase.CloneWithFilter(filter.NSName(b.ingresses...))
-		if err != nil {
-			ds.closeAll()
-			return nil, log.Err(err, "ingresses controller")
+	if sz := len(b.namespaces); sz > 0 && !b.allNamespaces {
+		ids := make([]nsname.NSName, 0, sz+len(b.addedNs))
+		for _, ns := range b.namespaces {
+			ids = append(ids, nsname.New(b.foldName(ns), ""))
+		}
+		for _, ns := range b.addedNs {
+			ids = append(ids, nsname.New(b.foldName(ns), ""))
+		}
+		filters = append(filters, filter.NSName(ids...))
+	}
+
+	if sz := len(b.excludedNs); sz > 0 {
+		ids := make([]nsname.NSName, 0, sz)
+		for _, ns := range b.excludedNs {
+			ids = append(ids, nsname.New(b.foldName(ns), ""))
 		}
+		filters = append(filters, filter.Not(filter.NSName(ids...)))
+	}
+
+	if len(b.nodes) != 0 {
+		filters = append(filters, pod.NodeFilter(b.nodes...))
+	}
+
+	if len(b.containerStates) != 0 {
+		filters = append(filters, containerStateFilter(b.containerStates))
+	}
+
+	if b.minRestarts != nil {
+		filters = append(filters, *b.minRestarts)
+	}
+
+	if b.minReady > 0 {
+		filters = append(filters, readyContainersFilter(b.minReady))
+	}
+
+	for _, c := range b.conditions {
+		filters = append(filters, c)
+	}
+
+	if b.age != (ageFilter{}) {
+		age := b.age
+		age.clock = b.clk()
+		filters = append(filters, age)
+	}
+
+	if b.hostNetwork != nil {
+		filters = append(filters, hostNetworkFilter(*b.hostNetwork))
+	}
+
+	if b.terminating != nil {
+		filters = append(filters, terminatingFilter(*b.terminating))
+	}
+
+	if b.noTerminated {
+		filters = append(filters, filter.Not(phaseFilter{v1.PodSucceeded, v1.PodFailed}))
+	}
+
+	if len(b.qosClasses) != 0 {
+		filters = append(filters, qosClassFilter(b.qosClasses))
+	}
+
+	if len(b.priorityClasses) != 0 {
+		filters = append(filters, priorityClassFilter(b.priorityClasses))
+	}
+
+	if len(b.podCIDRs) != 0 {
+		filters = append(filters, podCIDRFilter(b.podCIDRs))
+	}
+
+	if len(b.hostnames) != 0 {
+		filters = append(filters, hostnameFilter(b.hostnames))
+	}
 
-		ds.pods, err = join.IngressPods(ctx, ds.ingresses, ds.services, ds.pods)
+	if len(b.nodeSelKeys) != 0 {
+		filters = append(filters, nodeSelectorKeyFilter(b.nodeSelKeys))
+	}
+
+	if len(b.imageIDs) != 0 {
+		filters = append(filters, imageIDFilter(b.imageIDs))
+	}
+
+	return append(filters, b.filters...)
+}
+
+// newPodBase creates the base pod controller.  It watches only the pods
+// named with WithPods if podWatchIds returns them, and otherwise the pods in
+// ns, or in every namespace if ns is "".
+func (b *dsBuilder) newPodBase(ctx context.Context, log logutil.Log, cs kubernetes.Interface, ns string) (pod.Controller, error) {
+	if ids := b.podWatchIds(); ids != nil {
+		return pod.BuildController(ctx, log, podsClient{cs: cs, ids: ids})
+	}
+	return pod.NewController(ctx, log, cs, ns)
+}
+
+// podWatchIds returns the pods named with WithPods if there are at most
+// podWatchLimit, all in given namespaces, or nil otherwise.
+func (b *dsBuilder) podWatchIds() []nsname.NSName {
+	if len(b.pods) > podWatchLimit || !b.podsNameNamespaces() {
+		return nil
+	}
+	seen := make(map[nsname.NSName]bool, len(b.pods))
+	ids := make([]nsname.NSName, 0, len(b.pods))
+	for _, id := range b.pods {
+		id = nsname.New(b.foldName(id.Namespace), b.foldName(id.Name))
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// podNamespace returns the only namespace in which pods can match, or ""
+// if they can match in several or the pods are watched by podWatchIds.
+func (b *dsBuilder) podNamespace() string {
+	if b.podWatchIds() != nil {
+		return ""
+	}
+	if b.allNamespaces || b.nsSelector != nil || len(b.namespaces) == 0 || len(b.addedNs) != 0 {
+		return ""
+	}
+	ns := b.foldName(b.namespaces[0])
+	for _, name := range b.namespaces[1:] {
+		if b.foldName(name) != ns {
+			return ""
+		}
+	}
+	return ns
+}
+
+// foldName lower-cases name if names are matched case-insensitively.
+// Object names need no folding: the API only accepts lower-case names.
+func (b *dsBuilder) foldName(name string) string {
+	if b.foldNames {
+		return strings.ToLower(name)
+	}
+	return name
+}
+
+// labelKeySelector returns a selector requiring each of the given labels
+// to exist.
+func labelKeySelector(keys []string) (labels.Selector, error) {
+	selector := labels.NewSelector()
+	for _, key := range keys {
+		req, err := labels.NewRequirement(key, selection.Exists, nil)
 		if err != nil {
-			ds.closeAll()
-			return nil, log.Err(err, "ingress join")
+			return nil, fmt.Errorf("invalid label key '%v': %v", key, err)
 		}
+		selector = selector.Add(*req)
 	}
+	return selector, nil
+}
 
-	ds.run(ctx)
+func appendNames(parts []string, name string, vals []string) []string {
+	if len(vals) == 0 {
+		return parts
+	}
+	return append(parts, fmt.Sprintf("%v=[%v]", name, strings.Join(vals, ",")))
+}
 
-	return ds, nil
+func appendSelectors(parts []string, name string, selectors []labels.Selector) []string {
+	vals := make([]string, 0, len(selectors))
+	for _, selector := range selectors {
+		vals = append(vals, selector.String())
+	}
+	return appendNames(parts, name, vals)
+}
+
+func appendIds(parts []string, name string, ids []nsname.NSName) []string {
+	vals := make([]string, 0, len(ids))
+	for _, id := range ids {
+		vals = append(vals, fmt.Sprintf("%v/%v", id.Namespace, id.Name))
+	}
+	return appendNames(parts, name, vals)
 }
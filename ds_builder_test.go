@@ -0,0 +1,473 @@
+package kail
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/boz/kcache/filter"
+	"github.com/boz/kcache/nsname"
+	"github.com/boz/kcache/types/pod"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestBuilderClone(t *testing.T) {
+	web := labels.SelectorFromSet(labels.Set{"app": "web"})
+	worker := labels.SelectorFromSet(labels.Set{"app": "worker"})
+
+	newParent := func() DSBuilder {
+		return NewDSBuilder().
+			WithNamespace("default", "prod").
+			WithPods(nsname.New("default", "a")).
+			WithSelectors(web).
+			WithService(nsname.New("default", "web"))
+	}
+
+	tests := []struct {
+		name   string
+		mutate func(DSBuilder)
+	}{
+		{"namespace", func(b DSBuilder) { b.WithNamespace("other") }},
+		{"pod", func(b DSBuilder) { b.WithPods(nsname.New("default", "b")) }},
+		{"selector", func(b DSBuilder) { b.WithSelectors(worker) }},
+		{"any selector", func(b DSBuilder) { b.WithAnySelector(web, worker) }},
+		{"service", func(b DSBuilder) { b.WithService(nsname.New("default", "api")) }},
+		{"node", func(b DSBuilder) { b.WithNode("node-1") }},
+		{"reset", func(b DSBuilder) { b.Reset() }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parent := newParent()
+			want := parent.String()
+
+			clone := parent.Clone()
+			if got := clone.String(); got != want {
+				t.Fatalf("clone: got %q, want %q", got, want)
+			}
+
+			test.mutate(clone)
+			if clone.String() == want {
+				t.Fatalf("clone unchanged by mutation")
+			}
+			if got := parent.String(); got != want {
+				t.Errorf("parent changed: got %q, want %q", got, want)
+			}
+
+			// appending to the parent must not overwrite the clone's
+			// elements through a shared backing array.
+			mutated := clone.String()
+			test.mutate(parent)
+			if got := clone.String(); got != mutated {
+				t.Errorf("clone changed by parent: got %q, want %q", got, mutated)
+			}
+		})
+	}
+}
+
+func TestBuilderReset(t *testing.T) {
+	cs := fake.NewSimpleClientset(
+		testPod("default", "web", map[string]string{"app": "web"}),
+		testPod("default", "worker", map[string]string{"app": "worker"}),
+		testPod("kube-system", "dns", nil),
+	)
+
+	tracer := nullTracer{}
+	b := NewDSBuilder().
+		WithNamespace("default").
+		WithLabel("app", "web").
+		WithService(nsname.New("default", "web")).
+		WithTracer(tracer).
+		Reset()
+
+	if err := b.Validate(); err == nil {
+		t.Fatalf("validate: got nil error without namespaces")
+	}
+	if got := b.(*dsBuilder).tracer; got != tracer {
+		t.Errorf("tracer: got %v, want %v", got, tracer)
+	}
+
+	tests := []struct {
+		name    string
+		builder DSBuilder
+		want    []string
+	}{
+		{"namespace", b.Clone().WithNamespace("kube-system"), []string{"kube-system/dns"}},
+		{"all namespaces", b.Clone().WithAllNamespaces(),
+			[]string{"default/web", "default/worker", "kube-system/dns"}},
+		{"namespace after all namespaces", b.Clone().WithAllNamespaces().WithNamespace("kube-system"),
+			[]string{"kube-system/dns"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ds := createTestDS(t, test.builder, cs)
+			defer closeTestDS(t, ds)
+
+			waitMatched(t, ds, test.want...)
+		})
+	}
+}
+
+func TestBuilderValidateNamespaces(t *testing.T) {
+	tests := []struct {
+		name  string
+		b     DSBuilder
+		valid bool
+	}{
+		{"none", NewDSBuilder(), false},
+		{"label only", NewDSBuilder().WithLabel("app", "web"), false},
+		{"namespace", NewDSBuilder().WithNamespace("default"), true},
+		{"all namespaces", NewDSBuilder().WithAllNamespaces(), true},
+		{"namespace selector", NewDSBuilder().WithNamespaceSelector(labels.Everything()), true},
+		{"pods", NewDSBuilder().WithPods(nsname.New("default", "web")), true},
+		{"pod name", NewDSBuilder().WithPodName("default", "web"), true},
+		{"pod without namespace", NewDSBuilder().WithPods(nsname.New("", "web")), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.b.Validate()
+			switch {
+			case test.valid && err != nil:
+				t.Errorf("validate: %v", err)
+			case !test.valid && err == nil:
+				t.Errorf("validate: got nil error")
+			case !test.valid && !strings.Contains(err.Error(), "WithAllNamespaces"):
+				t.Errorf("validate: error %q does not suggest WithAllNamespaces", err)
+			}
+		})
+	}
+}
+
+func TestBuilderCreateRequiresNamespace(t *testing.T) {
+	cs := fake.NewSimpleClientset(testPod("default", "web", nil))
+
+	ds, err := NewDSBuilder().WithLabel("app", "web").Create(context.Background(), cs)
+	if err == nil {
+		closeTestDS(t, ds)
+		t.Fatalf("create: got nil error")
+	}
+}
+
+func TestBuilderSelectors(t *testing.T) {
+	parse := func(s string) labels.Selector {
+		selector, err := labels.Parse(s)
+		if err != nil {
+			t.Fatalf("parse %q: %v", s, err)
+		}
+		return selector
+	}
+
+	cs := fake.NewSimpleClientset(
+		testPod("default", "web", map[string]string{"app": "web", "tier": "front"}),
+		testPod("default", "worker", map[string]string{"app": "worker"}),
+		testPod("default", "db", map[string]string{"app": "db", "tier": "front"}),
+	)
+
+	tests := []struct {
+		name string
+		b    DSBuilder
+		want []string
+	}{
+		{"all", NewDSBuilder().
+			WithSelectors(parse("app=web"), parse("tier=front")),
+			[]string{"default/web"}},
+		{"any", NewDSBuilder().
+			WithAnySelector(parse("app=web"), parse("app=worker")),
+			[]string{"default/web", "default/worker"}},
+		{"single all", NewDSBuilder().
+			WithSelectors(parse("app=web")),
+			[]string{"default/web"}},
+		{"single any", NewDSBuilder().
+			WithAnySelector(parse("app=web")),
+			[]string{"default/web"}},
+		{"any and all", NewDSBuilder().
+			WithAnySelector(parse("app=web"), parse("app=worker")).
+			WithSelectors(parse("tier=front")),
+			[]string{"default/web"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ds := createTestDS(t, test.b.WithNamespace("default"), cs)
+			defer closeTestDS(t, ds)
+
+			waitMatched(t, ds, test.want...)
+		})
+	}
+}
+
+func TestBuilderWithAnySelectorCopies(t *testing.T) {
+	selectors := []labels.Selector{labels.SelectorFromSet(labels.Set{"app": "web"})}
+
+	b := NewDSBuilder().WithAllNamespaces().WithAnySelector(selectors...)
+	want := b.String()
+	clone := b.Clone()
+
+	selectors[0] = labels.SelectorFromSet(labels.Set{"app": "worker"})
+
+	if got := b.String(); got != want {
+		t.Errorf("builder changed by caller: got %q, want %q", got, want)
+	}
+	if got := clone.String(); got != want {
+		t.Errorf("clone changed by caller: got %q, want %q", got, want)
+	}
+}
+
+func TestBuilderWithLabel(t *testing.T) {
+	cs := fake.NewSimpleClientset(
+		testPod("default", "web", map[string]string{"app": "web", "tier": "front"}),
+		testPod("default", "api", map[string]string{"app": "web"}),
+		testPod("default", "worker", map[string]string{"app": "worker", "tier": "front"}),
+	)
+
+	tests := []struct {
+		name     string
+		label    DSBuilder
+		selector string
+	}{
+		{"single", NewDSBuilder().WithLabel("app", "web"), "app=web"},
+		{"multiple", NewDSBuilder().WithLabel("app", "web").WithLabel("tier", "front"), "app=web,tier=front"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			selector, err := labels.Parse(test.selector)
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+
+			parsed := createTestDS(t, NewDSBuilder().WithNamespace("default").WithSelectors(selector), cs)
+			defer closeTestDS(t, parsed)
+
+			label := createTestDS(t, test.label.WithNamespace("default"), cs)
+			defer closeTestDS(t, label)
+
+			want := matched(t, parsed)
+			if len(want) == 0 {
+				t.Fatalf("selector %q matched no pods", test.selector)
+			}
+			waitMatched(t, label, want...)
+		})
+	}
+}
+
+func TestBuilderWithLabelKey(t *testing.T) {
+	cs := fake.NewSimpleClientset(
+		testPod("default", "both", map[string]string{"monitoring": "true", "team": "core"}),
+		testPod("default", "monitored", map[string]string{"monitoring": ""}),
+		testPod("default", "team", map[string]string{"team": "core"}),
+		testPod("default", "none", nil),
+	)
+
+	tests := []struct {
+		name string
+		keys []string
+		want []string
+	}{
+		{"single", []string{"monitoring"}, []string{"default/both", "default/monitored"}},
+		{"multiple", []string{"monitoring", "team"}, []string{"default/both"}},
+		{"missing", []string{"absent"}, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := NewDSBuilder().WithNamespace("default").WithLabelKey(test.keys...)
+			ds := createTestDS(t, b, cs)
+			defer closeTestDS(t, ds)
+
+			waitMatched(t, ds, test.want...)
+		})
+	}
+}
+
+func TestBuilderEqual(t *testing.T) {
+	web := labels.SelectorFromSet(labels.Set{"app": "web"})
+	api := labels.SelectorFromSet(labels.Set{"app": "api"})
+	join := func(_ context.Context, pods pod.Controller) (pod.Controller, error) {
+		return pods, nil
+	}
+	withJoin := NewDSBuilder().WithNamespace("a").WithJoin(join)
+
+	tests := []struct {
+		name  string
+		a, b  DSBuilder
+		equal bool
+	}{
+		{"namespace order",
+			NewDSBuilder().WithNamespace("a", "b"),
+			NewDSBuilder().WithNamespace("b", "a"), true},
+		{"selector order",
+			NewDSBuilder().WithNamespace("a").WithSelectors(web, api),
+			NewDSBuilder().WithNamespace("a").WithSelectors(api).WithSelectors(web), true},
+		{"service order",
+			NewDSBuilder().WithAllNamespaces().WithService(nsname.New("a", "web"), nsname.New("b", "web")),
+			NewDSBuilder().WithAllNamespaces().WithService(nsname.New("b", "web"), nsname.New("a", "web")), true},
+		{"duplicates",
+			NewDSBuilder().WithNamespace("a", "b", "a"),
+			NewDSBuilder().WithNamespace("a", "b"), true},
+		{"folded case",
+			NewDSBuilder().WithCaseInsensitiveNames().WithNamespace("Prod"),
+			NewDSBuilder().WithCaseInsensitiveNames().WithNamespace("prod"), true},
+
+		{"namespaces differ",
+			NewDSBuilder().WithNamespace("a", "b"),
+			NewDSBuilder().WithNamespace("a", "c"), false},
+		{"selectors differ",
+			NewDSBuilder().WithNamespace("a").WithSelectors(web),
+			NewDSBuilder().WithNamespace("a").WithSelectors(api), false},
+		{"case",
+			NewDSBuilder().WithNamespace("Prod"),
+			NewDSBuilder().WithNamespace("prod"), false},
+		{"case folding",
+			NewDSBuilder().WithCaseInsensitiveNames().WithNamespace("Prod"),
+			NewDSBuilder().WithNamespace("Prod"), false},
+		{"all namespaces",
+			NewDSBuilder().WithAllNamespaces(),
+			NewDSBuilder().WithNamespace("a"), false},
+		{"join", withJoin, NewDSBuilder().WithNamespace("a"), false},
+		{"same join", withJoin, withJoin.Clone(), false},
+		{"filter",
+			NewDSBuilder().WithNamespace("a").WithFilter(filter.Null()),
+			NewDSBuilder().WithNamespace("a"), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.a.Equal(test.b); got != test.equal {
+				t.Errorf("a.Equal(b): got %v, want %v", got, test.equal)
+			}
+			if got := test.b.Equal(test.a); got != test.equal {
+				t.Errorf("b.Equal(a): got %v, want %v", got, test.equal)
+			}
+			if got := test.a.Hash() == test.b.Hash(); got != test.equal {
+				t.Errorf("hashes equal: got %v, want %v", got, test.equal)
+			}
+		})
+	}
+}
+
+func TestBuilderWithHostNetwork(t *testing.T) {
+	hostPod := testPod("default", "host", nil)
+	hostPod.Spec.HostNetwork = true
+
+	cs := fake.NewSimpleClientset(hostPod, testPod("default", "pod", nil))
+
+	tests := []struct {
+		name    string
+		enabled bool
+		want    []string
+	}{
+		{"enabled", true, []string{"default/host"}},
+		{"disabled", false, []string{"default/pod"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := NewDSBuilder().WithNamespace("default").WithHostNetwork(test.enabled)
+			ds := createTestDS(t, b, cs)
+			defer closeTestDS(t, ds)
+
+			waitMatched(t, ds, test.want...)
+		})
+	}
+}
+
+func TestBuilderNormalize(t *testing.T) {
+	web := labels.SelectorFromSet(labels.Set{"app": "web"})
+	svc := nsname.New("default", "web")
+
+	tests := []struct {
+		name      string
+		duplicate DSBuilder
+		unique    DSBuilder
+	}{
+		{"namespaces",
+			NewDSBuilder().WithNamespace("default", "prod", "default"),
+			NewDSBuilder().WithNamespace("default", "prod")},
+		{"selectors",
+			NewDSBuilder().WithNamespace("default").WithSelectors(web, web).WithSelectors(web),
+			NewDSBuilder().WithNamespace("default").WithSelectors(web)},
+		{"labels",
+			NewDSBuilder().WithNamespace("default").WithLabel("app", "web").WithLabel("app", "web"),
+			NewDSBuilder().WithNamespace("default").WithLabel("app", "web")},
+		{"services",
+			NewDSBuilder().WithNamespace("default").WithService(svc, svc).WithService(svc),
+			NewDSBuilder().WithNamespace("default").WithService(svc)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cs := fake.NewSimpleClientset(
+				testPod("default", "web", map[string]string{"app": "web"}),
+				testPod("default", "worker", map[string]string{"app": "worker"}),
+				testPod("prod", "web", map[string]string{"app": "web"}),
+				testService("default", "web", map[string]string{"app": "web"}),
+			)
+
+			dup := test.duplicate.(*dsBuilder)
+			if got, want := dup.normalize().String(), test.unique.String(); got != want {
+				t.Errorf("normalize: got %q, want %q", got, want)
+			}
+			if got, want := len(dup.normalize().podFilters()), len(test.unique.(*dsBuilder).podFilters()); got != want {
+				t.Errorf("filters: got %v, want %v", got, want)
+			}
+
+			uds := createTestDS(t, test.unique, cs)
+			defer closeTestDS(t, uds)
+			dds := createTestDS(t, test.duplicate, cs)
+			defer closeTestDS(t, dds)
+
+			// the datastore is built from the deduplicated criteria.
+			if got, want := dds.(*datastore).chain().builder().String(), test.unique.String(); got != want {
+				t.Errorf("datastore criteria: got %q, want %q", got, want)
+			}
+
+			want := matched(t, uds)
+			waitMatched(t, dds, want...)
+		})
+	}
+}
+
+func TestBuilderWithPodName(t *testing.T) {
+	cs := fake.NewSimpleClientset(
+		testPod("default", "web", nil),
+		testPod("default", "api", nil),
+		testPod("prod", "web", nil),
+	)
+
+	tests := []struct {
+		name  string
+		ns    string
+		names []string
+	}{
+		{"single", "default", []string{"web"}},
+		{"multiple", "default", []string{"web", "api"}},
+		{"other namespace", "prod", []string{"web"}},
+		{"missing", "default", []string{"absent"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var ids []nsname.NSName
+			for _, name := range test.names {
+				ids = append(ids, nsname.New(test.ns, name))
+			}
+
+			byName := NewDSBuilder().WithPodName(test.ns, test.names...)
+			byID := NewDSBuilder().WithPods(ids...)
+			if got, want := byName.String(), byID.String(); got != want {
+				t.Errorf("builder: got %q, want %q", got, want)
+			}
+
+			idDS := createTestDS(t, byID, cs)
+			defer closeTestDS(t, idDS)
+			nameDS := createTestDS(t, byName, cs)
+			defer closeTestDS(t, nameDS)
+
+			waitMatched(t, nameDS, matched(t, idDS)...)
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package kail
+
+import (
+	"fmt"
+	"sync"
+
+	logutil "github.com/boz/go-logutil"
+	"github.com/boz/kcache/nsname"
+	"github.com/boz/kcache/types/daemonset"
+	"github.com/boz/kcache/types/deployment"
+	"github.com/boz/kcache/types/ingress"
+	"github.com/boz/kcache/types/node"
+	"github.com/boz/kcache/types/pod"
+	"github.com/boz/kcache/types/replicaset"
+	"github.com/boz/kcache/types/replicationcontroller"
+	"github.com/boz/kcache/types/service"
+	"k8s.io/api/core/v1"
+)
+
+// dsChain is one generation of the controllers that compute a datastore's
+// matched pods: the base controllers, their filtered clones and the stages
+// joining them.  Refresh and Reconfigure build a new chain and swap it in
+// once it is ready, retiring the previous one.
+type dsChain struct {
+	podBase         pod.Controller
+	servicesBase    service.Controller
+	nodesBase       node.Controller
+	rcsBase         replicationcontroller.Controller
+	rssBase         replicaset.Controller
+	dssBase         daemonset.Controller
+	deploymentsBase deployment.Controller
+	ingressesBase   ingress.Controller
+
+	podBaseShared bool
+
+	// podNamespace is the only namespace podBase watches, if any.
+	podNamespace string
+
+	// criteria is the first filtered stage, holding the criteria evaluated
+	// directly against pods.  It is refiltered from criteriaBuilder by
+	// AddNamespace.
+	criteria        pod.FilterController
+	criteriaBuilder *dsBuilder
+	criteriaMu      sync.Mutex
+
+	pods        pod.Controller
+	services    service.Controller
+	nodes       node.Controller
+	rcs         replicationcontroller.Controller
+	rss         replicaset.Controller
+	dss         daemonset.Controller
+	deployments deployment.Controller
+	dcRCs       replicationcontroller.Controller
+	ingresses   ingress.Controller
+
+	// revisionRSs holds the replica sets watched for
+	// WithDeploymentAllRevisions.
+	revisionRSs replicaset.Controller
+
+	// podStages holds every pod controller the chain has derived from
+	// podBase, in order; pods is the last.  Each subscribes to the one
+	// before it, so all must be closed when podBase is shared.
+	podStages []pod.Controller
+
+	stages []*dynamicStage
+
+	// nsStage applies WithNamespaceSelector, if given.
+	nsStage *dynamicStage
+
+	// closech is closed with the chain, stopping its watchers.
+	closech   chan struct{}
+	closeOnce sync.Once
+
+	// replacedch is closed once another chain has been swapped in.
+	replacedch chan struct{}
+
+	log logutil.Log
+}
+
+func newDSChain(log logutil.Log) *dsChain {
+	return &dsChain{
+		closech:    make(chan struct{}),
+		replacedch: make(chan struct{}),
+		log:        log,
+	}
+}
+
+// builder returns the criteria the chain was built from.
+func (c *dsChain) builder() *dsBuilder {
+	c.criteriaMu.Lock()
+	defer c.criteriaMu.Unlock()
+	return c.criteriaBuilder
+}
+
+// addedNamespaces returns the namespaces added with AddNamespace.
+func (c *dsChain) addedNamespaces() []string {
+	c.criteriaMu.Lock()
+	defer c.criteriaMu.Unlock()
+	return append([]string(nil), c.criteriaBuilder.addedNs...)
+}
+
+// staticChanges returns changes for a stage whose filter only changes when
+// it is refreshed: it is signalled once, and closed with the chain.
+func (c *dsChain) staticChanges() <-chan struct{} {
+	ch := make(chan struct{}, 1)
+	ch <- struct{}{}
+	go func() {
+		<-c.closech
+		close(ch)
+	}()
+	return ch
+}
+
+// setPods makes pods, derived from the current pods, the chain's matched
+// pods.
+func (c *dsChain) setPods(pods pod.Controller) {
+	c.podStages = append(c.podStages, pods)
+	c.pods = pods
+}
+
+func (c *dsChain) get(id nsname.NSName) (*v1.Pod, bool) {
+	pod, err := c.pods.Cache().Get(id.Namespace, id.Name)
+	if err != nil || pod == nil {
+		return nil, false
+	}
+	return pod, true
+}
+
+func (c *dsChain) close() {
+	c.closeOnce.Do(func() {
+		close(c.closech)
+		for _, cc := range c.controllers() {
+			cc.Close()
+		}
+	})
+}
+
+// abort closes the chain, blocking until every controller created so far
+// has completed.
+func (c *dsChain) abort() {
+	c.close()
+	for _, cc := range c.controllers() {
+		<-cc.Done()
+	}
+}
+
+// waitReady blocks until every controller and stage of the chain is ready,
+// returning an error if one finishes first or donech is closed.
+func (c *dsChain) waitReady(donech <-chan struct{}) error {
+	for _, cc := range c.controllers() {
+		select {
+		case <-cc.Ready():
+		case <-cc.Done():
+			return fmt.Errorf("%v controller done before ready", c.controllerName(cc))
+		case <-donech:
+			return fmt.Errorf("datastore closed")
+		}
+	}
+	for _, s := range c.stages {
+		select {
+		case <-s.readych:
+		case <-c.pods.Done():
+			return fmt.Errorf("%v stage done before ready", s.name)
+		case <-donech:
+			return fmt.Errorf("datastore closed")
+		}
+	}
+	return nil
+}
+
+func (c *dsChain) controllers() []cacheController {
+
+	var podBase cacheController
+	if !c.podBaseShared {
+		podBase = c.podBase
+	}
+
+	potential := []cacheController{
+		podBase,
+		c.servicesBase,
+		c.nodesBase,
+		c.rcsBase,
+		c.rssBase,
+		c.dssBase,
+		c.deploymentsBase,
+		c.ingressesBase,
+		c.pods,
+		c.services,
+		c.nodes,
+		c.rcs,
+		c.rss,
+		c.dss,
+		c.deployments,
+		c.dcRCs,
+		c.ingresses,
+		c.revisionRSs,
+	}
+	for _, pods := range c.podStages {
+		potential = append(potential, pods)
+	}
+
+	var existing []cacheController
+	for _, cc := range potential {
+		if cc != nil && !containsController(existing, cc) {
+			existing = append(existing, cc)
+		}
+	}
+	return existing
+}
+
+// controllerName describes cc for diagnostics.
+func (c *dsChain) controllerName(cc cacheController) string {
+	switch cc {
+	case c.pods:
+		return "pod"
+	case c.podBase:
+		return "base pod"
+	case c.services:
+		return "service"
+	case c.servicesBase:
+		return "base service"
+	case c.nodes:
+		return "node"
+	case c.nodesBase:
+		return "base node"
+	case c.rcs:
+		return "rc"
+	case c.rcsBase:
+		return "base rc"
+	case c.rss:
+		return "rs"
+	case c.rssBase:
+		return "base rs"
+	case c.dss:
+		return "ds"
+	case c.dssBase:
+		return "base ds"
+	case c.deployments:
+		return "deployment"
+	case c.deploymentsBase:
+		return "base deployment"
+	case c.dcRCs:
+		return "deployment config rc"
+	case c.ingresses:
+		return "ingress"
+	case c.ingressesBase:
+		return "base ingress"
+	case c.revisionRSs:
+		return "deployment revisions rs"
+	}
+	for _, pods := range c.podStages {
+		if cc == pods {
+			return "pod stage"
+		}
+	}
+	return "unknown"
+}
+
+func containsController(controllers []cacheController, c cacheController) bool {
+	for _, existing := range controllers {
+		if existing == c {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,132 @@
+package kail
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/boz/kcache/nsname"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+type Config struct {
+	Ignore      []string `json:"ignore,omitempty"`
+	Selectors   []string `json:"selectors,omitempty"`
+	Pods        []string `json:"pods,omitempty"`
+	Namespaces  []string `json:"namespaces,omitempty"`
+	Services    []string `json:"services,omitempty"`
+	Nodes       []string `json:"nodes,omitempty"`
+	RCs         []string `json:"rcs,omitempty"`
+	RSs         []string `json:"rss,omitempty"`
+	DSs         []string `json:"dss,omitempty"`
+	Deployments []string `json:"deployments,omitempty"`
+	DCs         []string `json:"dcs,omitempty"`
+	Ingresses   []string `json:"ingresses,omitempty"`
+
+	// AllNamespaces must be set to watch the entire cluster when no
+	// namespaces are given.
+	AllNamespaces bool `json:"allNamespaces,omitempty"`
+}
+
+func NewDSBuilderFromConfig(cfg Config) (DSBuilder, error) {
+	b := NewDSBuilder()
+
+	if selectors, err := parseSelectors("ignore", cfg.Ignore); err != nil {
+		return nil, err
+	} else if len(selectors) > 0 {
+		b = b.WithIgnore(selectors...)
+	}
+
+	if selectors, err := parseSelectors("selectors", cfg.Selectors); err != nil {
+		return nil, err
+	} else if len(selectors) > 0 {
+		b = b.WithSelectors(selectors...)
+	}
+
+	if ids, err := parseIds("pods", cfg.Pods); err != nil {
+		return nil, err
+	} else if len(ids) > 0 {
+		b = b.WithPods(ids...)
+	}
+
+	if cfg.AllNamespaces {
+		b = b.WithAllNamespaces()
+	} else if len(cfg.Namespaces) > 0 {
+		b = b.WithNamespace(cfg.Namespaces...)
+	}
+
+	if ids, err := parseIds("services", cfg.Services); err != nil {
+		return nil, err
+	} else if len(ids) > 0 {
+		b = b.WithService(ids...)
+	}
+
+	if len(cfg.Nodes) > 0 {
+		b = b.WithNode(cfg.Nodes...)
+	}
+
+	if ids, err := parseIds("rcs", cfg.RCs); err != nil {
+		return nil, err
+	} else if len(ids) > 0 {
+		b = b.WithRC(ids...)
+	}
+
+	if ids, err := parseIds("rss", cfg.RSs); err != nil {
+		return nil, err
+	} else if len(ids) > 0 {
+		b = b.WithRS(ids...)
+	}
+
+	if ids, err := parseIds("dss", cfg.DSs); err != nil {
+		return nil, err
+	} else if len(ids) > 0 {
+		b = b.WithDS(ids...)
+	}
+
+	if ids, err := parseIds("deployments", cfg.Deployments); err != nil {
+		return nil, err
+	} else if len(ids) > 0 {
+		b = b.WithDeployment(ids...)
+	}
+
+	if ids, err := parseIds("dcs", cfg.DCs); err != nil {
+		return nil, err
+	} else if len(ids) > 0 {
+		b = b.WithDeploymentConfig(ids...)
+	}
+
+	if ids, err := parseIds("ingresses", cfg.Ingresses); err != nil {
+		return nil, err
+	} else if len(ids) > 0 {
+		b = b.WithIngress(ids...)
+	}
+
+	return b, nil
+}
+
+func parseSelectors(field string, vals []string) ([]labels.Selector, error) {
+	var selectors []labels.Selector
+	for _, val := range vals {
+		selector, err := labels.Parse(val)
+		if err != nil {
+			return nil, fmt.Errorf("%v: invalid labels expression '%v': %v", field, val, err)
+		}
+		selectors = append(selectors, selector)
+	}
+	return selectors, nil
+}
+
+func parseIds(field string, vals []string) ([]nsname.NSName, error) {
+	var ids []nsname.NSName
+	for _, val := range vals {
+		parts := strings.Split(val, "/")
+		switch len(parts) {
+		case 2:
+			ids = append(ids, nsname.New(parts[0], parts[1]))
+		case 1:
+			ids = append(ids, nsname.New("", parts[0]))
+		default:
+			return nil, fmt.Errorf("%v: invalid name '%v'", field, val)
+		}
+	}
+	return ids, nil
+}
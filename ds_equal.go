@@ -0,0 +1,250 @@
+package kail
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/boz/kcache/nsname"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func (b *dsBuilder) Equal(other DSBuilder) bool {
+	o, ok := other.(*dsBuilder)
+	if !ok {
+		return false
+	}
+	if !b.comparable() || !o.comparable() {
+		return false
+	}
+	return b.canonical() == o.canonical()
+}
+
+func (b *dsBuilder) Hash() string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(b.canonical())))
+}
+
+// comparable reports whether the builder's selection can be described by
+// canonical: filters and joins are functions, which cannot be compared.
+func (b *dsBuilder) comparable() bool {
+	return len(b.filters) == 0 && len(b.joins) == 0
+}
+
+// canonical describes the builder's selection independently of the order
+// in which criteria were given, of duplicates and, with
+// WithCaseInsensitiveNames, of the case of the names it folds.  The
+// description of a builder that is not comparable is unique to it.
+func (b *dsBuilder) canonical() string {
+	c := b.Clone().(*dsBuilder)
+
+	if c.foldNames {
+		for _, vals := range []*[]string{&c.namespaces, &c.excludedNs, &c.podGlobs} {
+			for i, val := range *vals {
+				(*vals)[i] = c.foldName(val)
+			}
+		}
+		for i, id := range c.pods {
+			c.pods[i] = nsname.New(c.foldName(id.Namespace), c.foldName(id.Name))
+		}
+	}
+
+	c = c.normalize()
+
+	if c.allNamespaces {
+		c.namespaces = nil
+	}
+
+	sortSelectors(c.ignore)
+	sortSelectors(c.selectors)
+	sortSelectors(c.nodeLabels)
+	for _, selectors := range c.anySelectors {
+		sortSelectors(selectors)
+	}
+	sort.Slice(c.anySelectors, func(i, j int) bool {
+		return selectorsString(c.anySelectors[i]) < selectorsString(c.anySelectors[j])
+	})
+
+	sort.Strings(c.labelKeys)
+	sort.Strings(c.podGlobs)
+	sort.Strings(c.namespaces)
+	sort.Strings(c.excludedNs)
+	sort.Strings(c.nodes)
+	sort.Strings(c.containerStates)
+	sort.Strings(c.priorityClasses)
+	sort.Strings(c.hostnames)
+	sort.Strings(c.nodeSelKeys)
+	sort.Strings(c.imageIDs)
+
+	sort.Slice(c.podRegexps, func(i, j int) bool {
+		return c.podRegexps[i].String() < c.podRegexps[j].String()
+	})
+	sort.Slice(c.serviceTypes, func(i, j int) bool {
+		return c.serviceTypes[i] < c.serviceTypes[j]
+	})
+	sort.Slice(c.conditions, func(i, j int) bool {
+		if c.conditions[i].condType != c.conditions[j].condType {
+			return c.conditions[i].condType < c.conditions[j].condType
+		}
+		return c.conditions[i].status < c.conditions[j].status
+	})
+	sort.Slice(c.podCIDRs, func(i, j int) bool {
+		return c.podCIDRs[i].String() < c.podCIDRs[j].String()
+	})
+	sort.Slice(c.qosClasses, func(i, j int) bool {
+		return c.qosClasses[i] < c.qosClasses[j]
+	})
+
+	for _, ids := range [][]nsname.NSName{
+		c.pods, c.services, c.rcs, c.rss, c.dss, c.deployments, c.deploymentRevs,
+		c.dcs, c.ingresses, c.pdbs,
+	} {
+		sortIds(ids)
+	}
+
+	if !b.comparable() {
+		return fmt.Sprintf("%v uncomparable=%p", c.String(), b)
+	}
+	return c.String()
+}
+
+func sortSelectors(selectors []labels.Selector) {
+	sort.Slice(selectors, func(i, j int) bool {
+		return selectors[i].String() < selectors[j].String()
+	})
+}
+
+func selectorsString(selectors []labels.Selector) string {
+	vals := make([]string, 0, len(selectors))
+	for _, selector := range selectors {
+		vals = append(vals, selector.String())
+	}
+	return strings.Join(vals, " | ")
+}
+
+func sortIds(ids []nsname.NSName) {
+	sort.Slice(ids, func(i, j int) bool {
+		if ids[i].Namespace != ids[j].Namespace {
+			return ids[i].Namespace < ids[j].Namespace
+		}
+		return ids[i].Name < ids[j].Name
+	})
+}
+
+// normalize returns a copy of the builder with duplicate criteria removed,
+// keeping the first occurrence of each.  Duplicates never change the
+// matched set but each may cost a filter or controller.
+func (b *dsBuilder) normalize() *dsBuilder {
+	c := b.Clone().(*dsBuilder)
+
+	c.ignore = dedupeSelectors(c.ignore)
+	c.selectors = dedupeSelectors(c.selectors)
+	c.nodeLabels = dedupeSelectors(c.nodeLabels)
+	for i, selectors := range c.anySelectors {
+		// the inner slices are shared with b.
+		c.anySelectors[i] = dedupeSelectors(append([]labels.Selector(nil), selectors...))
+	}
+
+	for _, vals := range []*[]string{
+		&c.namespaces, &c.excludedNs, &c.nodes, &c.labelKeys, &c.podGlobs,
+		&c.containerStates, &c.priorityClasses, &c.hostnames, &c.nodeSelKeys,
+		&c.imageIDs, &c.containerInclude, &c.containerExclude,
+	} {
+		*vals = dedupeStrings(*vals)
+	}
+
+	for _, ids := range []*[]nsname.NSName{
+		&c.pods, &c.services, &c.rcs, &c.rss, &c.dss, &c.deployments, &c.deploymentRevs,
+		&c.dcs, &c.ingresses, &c.pdbs,
+	} {
+		*ids = dedupeIds(*ids)
+	}
+
+	regexps := c.podRegexps[:0]
+	seen := make(map[string]bool)
+	for _, re := range c.podRegexps {
+		if !seen[re.String()] {
+			seen[re.String()] = true
+			regexps = append(regexps, re)
+		}
+	}
+	c.podRegexps = regexps
+
+	cidrs := c.podCIDRs[:0]
+	seen = make(map[string]bool)
+	for _, ipnet := range c.podCIDRs {
+		if !seen[ipnet.String()] {
+			seen[ipnet.String()] = true
+			cidrs = append(cidrs, ipnet)
+		}
+	}
+	c.podCIDRs = cidrs
+
+	serviceTypes := c.serviceTypes[:0]
+	seen = make(map[string]bool)
+	for _, t := range c.serviceTypes {
+		if !seen[string(t)] {
+			seen[string(t)] = true
+			serviceTypes = append(serviceTypes, t)
+		}
+	}
+	c.serviceTypes = serviceTypes
+
+	qosClasses := c.qosClasses[:0]
+	seen = make(map[string]bool)
+	for _, class := range c.qosClasses {
+		if !seen[string(class)] {
+			seen[string(class)] = true
+			qosClasses = append(qosClasses, class)
+		}
+	}
+	c.qosClasses = qosClasses
+
+	conditions := c.conditions[:0]
+	seenConditions := make(map[conditionFilter]bool)
+	for _, cond := range c.conditions {
+		if !seenConditions[cond] {
+			seenConditions[cond] = true
+			conditions = append(conditions, cond)
+		}
+	}
+	c.conditions = conditions
+
+	return c
+}
+
+func dedupeStrings(vals []string) []string {
+	out := vals[:0]
+	seen := make(map[string]bool)
+	for _, val := range vals {
+		if !seen[val] {
+			seen[val] = true
+			out = append(out, val)
+		}
+	}
+	return out
+}
+
+func dedupeIds(ids []nsname.NSName) []nsname.NSName {
+	out := ids[:0]
+	seen := make(map[nsname.NSName]bool)
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+func dedupeSelectors(selectors []labels.Selector) []labels.Selector {
+	out := selectors[:0]
+	seen := make(map[string]bool)
+	for _, selector := range selectors {
+		if !seen[selector.String()] {
+			seen[selector.String()] = true
+			out = append(out, selector)
+		}
+	}
+	return out
+}
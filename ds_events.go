@@ -0,0 +1,120 @@
+package kail
+
+import (
+	"time"
+
+	logutil "github.com/boz/go-logutil"
+	"github.com/boz/kcache/nsname"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	kubeEventBufsiz     = 100
+	kubeEventRetryDelay = time.Second
+)
+
+// watchKubeEvents forwards cluster events involving matched pods to
+// kubeEventsch until the datastore is closed.  Events are dropped if the
+// channel is full.  Events that exist when the datastore is created are not
+// forwarded.
+func (ds *datastore) watchKubeEvents(cs kubernetes.Interface) {
+	defer close(ds.kubeEventsch)
+
+	log := ds.log.WithComponent("kail.ds.events")
+
+	opts := metav1.ListOptions{FieldSelector: "involvedObject.kind=Pod"}
+
+	for {
+		// a watch from an empty resource version replays every existing
+		// event; start from the version at which they were listed instead.
+		if opts.ResourceVersion == "" {
+			list, err := cs.CoreV1().Events("").List(opts)
+			if err != nil {
+				log.ErrWarn(err, "listing events")
+				if !ds.retryKubeEvents() {
+					return
+				}
+				continue
+			}
+			opts.ResourceVersion = list.ResourceVersion
+		}
+
+		w, err := cs.CoreV1().Events("").Watch(opts)
+		if err != nil {
+			log.ErrWarn(err, "watching events")
+			if !ds.retryKubeEvents() {
+				return
+			}
+			continue
+		}
+
+		if !ds.forwardKubeEvents(w, &opts, log) {
+			return
+		}
+	}
+}
+
+// retryKubeEvents waits to retry watching events, returning false if the
+// datastore was closed first.
+func (ds *datastore) retryKubeEvents() bool {
+	select {
+	case <-ds.clock.After(kubeEventRetryDelay):
+		return true
+	case <-ds.closech:
+		return false
+	}
+}
+
+// forwardKubeEvents reads w until it ends, returning false if the datastore
+// was closed.  opts is updated to resume from the last event seen.
+func (ds *datastore) forwardKubeEvents(w watch.Interface, opts *metav1.ListOptions, log logutil.Log) bool {
+	defer w.Stop()
+
+	for {
+		select {
+		case <-ds.closech:
+			return false
+
+		case wev, ok := <-w.ResultChan():
+			if !ok {
+				return true
+			}
+
+			if wev.Type == watch.Error {
+				err := errors.FromObject(wev.Object)
+				if errors.IsGone(err) || errors.ReasonForError(err) == metav1.StatusReasonExpired {
+					// the last version seen has been compacted; relist
+					// rather than replay, losing the events in between.
+					opts.ResourceVersion = ""
+				}
+				log.ErrWarn(err, "watching events")
+				return true
+			}
+
+			ev, ok := wev.Object.(*v1.Event)
+			if !ok {
+				continue
+			}
+			opts.ResourceVersion = ev.ResourceVersion
+
+			if wev.Type == watch.Deleted {
+				continue
+			}
+
+			ref := ev.InvolvedObject
+			if _, ok := ds.Get(nsname.New(ref.Namespace, ref.Name)); !ok {
+				continue
+			}
+
+			select {
+			case ds.kubeEventsch <- ev:
+			default:
+				ds.log.Debugf("dropping event %v/%v: buffer full", ev.Namespace, ev.Name)
+			}
+		}
+	}
+}
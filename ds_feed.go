@@ -0,0 +1,256 @@
+package kail
+
+import (
+	"sort"
+	"sync"
+
+	logutil "github.com/boz/go-logutil"
+	"github.com/boz/kcache"
+	"github.com/boz/kcache/nsname"
+	"github.com/boz/kcache/types/pod"
+	"k8s.io/api/core/v1"
+)
+
+// podFeed is a subscription to a datastore's matched pods that follows it
+// across chain swaps.  When a new chain is swapped in, the feed moves to a
+// subscription of the new chain's pods and emits the differences between
+// the pods it has delivered and those the new chain matches, so that its
+// reader sees only pods that were added, removed or changed.
+type podFeed struct {
+	sub   pod.Subscription
+	subMu sync.Mutex
+
+	swapch  chan pod.Subscription
+	eventch chan pod.Event
+	readych chan struct{}
+
+	closech   chan struct{}
+	closeOnce sync.Once
+	donech    chan struct{}
+
+	log logutil.Log
+}
+
+func newPodFeed(sub pod.Subscription, log logutil.Log) *podFeed {
+	f := &podFeed{
+		sub:     sub,
+		swapch:  make(chan pod.Subscription),
+		eventch: make(chan pod.Event, dsEventBufsiz),
+		readych: make(chan struct{}),
+		closech: make(chan struct{}),
+		donech:  make(chan struct{}),
+		log:     log,
+	}
+	go f.run()
+	return f
+}
+
+func (f *podFeed) Cache() pod.CacheReader {
+	return podFeedCache{f}
+}
+
+func (f *podFeed) Ready() <-chan struct{} {
+	return f.readych
+}
+
+func (f *podFeed) Events() <-chan pod.Event {
+	return f.eventch
+}
+
+func (f *podFeed) Close() {
+	f.closeOnce.Do(func() { close(f.closech) })
+}
+
+func (f *podFeed) Done() <-chan struct{} {
+	return f.donech
+}
+
+// swap moves the feed to sub, a subscription of a newly swapped-in chain.
+// It returns once the feed has stopped reading from its previous
+// subscription.
+func (f *podFeed) swap(sub pod.Subscription) {
+	select {
+	case f.swapch <- sub:
+	case <-f.donech:
+		sub.Close()
+	}
+}
+
+func (f *podFeed) current() pod.Subscription {
+	f.subMu.Lock()
+	defer f.subMu.Unlock()
+	return f.sub
+}
+
+func (f *podFeed) setCurrent(sub pod.Subscription) {
+	f.subMu.Lock()
+	defer f.subMu.Unlock()
+	f.sub = sub
+}
+
+func (f *podFeed) run() {
+	defer close(f.donech)
+	defer close(f.eventch)
+	defer func() { f.current().Close() }()
+
+	sub := f.current()
+
+	// delivered holds the pods the reader has seen, once ready.
+	var delivered map[nsname.NSName]*v1.Pod
+
+	for {
+		readych := sub.Ready()
+		if delivered != nil {
+			readych = nil
+		}
+
+		select {
+		case <-readych:
+			delivered = make(map[nsname.NSName]*v1.Pod)
+			pods, err := sub.Cache().List()
+			if err != nil {
+				f.log.ErrWarn(err, "listing pods")
+			}
+			for _, pod := range pods {
+				delivered[nsname.ForObject(pod)] = pod
+			}
+			close(f.readych)
+
+		case ev, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			if delivered == nil {
+				continue
+			}
+			id := nsname.ForObject(ev.Resource())
+			if ev.Type() == kcache.EventTypeDelete {
+				delete(delivered, id)
+			} else {
+				delivered[id] = ev.Resource()
+			}
+			if !f.send(ev) {
+				return
+			}
+
+		case next := <-f.swapch:
+			f.setCurrent(next)
+			sub.Close()
+			sub = next
+			if delivered == nil {
+				continue
+			}
+			select {
+			case <-sub.Ready():
+			case <-sub.Done():
+				return
+			case <-f.closech:
+				return
+			}
+			var ok bool
+			if delivered, ok = f.sendDiff(delivered, sub); !ok {
+				return
+			}
+
+		case <-sub.Done():
+			return
+
+		case <-f.closech:
+			return
+		}
+	}
+}
+
+// sendDiff sends the events that take a reader from the delivered pods to
+// those currently in sub, returning the latter.
+func (f *podFeed) sendDiff(
+	delivered map[nsname.NSName]*v1.Pod, sub pod.Subscription) (map[nsname.NSName]*v1.Pod, bool) {
+
+	pods, err := sub.Cache().List()
+	if err != nil {
+		f.log.ErrWarn(err, "listing pods")
+		return delivered, true
+	}
+
+	current := make(map[nsname.NSName]*v1.Pod, len(pods))
+	for _, pod := range pods {
+		current[nsname.ForObject(pod)] = pod
+	}
+
+	var events []pod.Event
+	for id, pod := range delivered {
+		if _, ok := current[id]; !ok {
+			events = append(events, podEvent{kcache.EventTypeDelete, pod})
+		}
+	}
+	for id, pod := range current {
+		prev, ok := delivered[id]
+		switch {
+		case !ok:
+			events = append(events, podEvent{kcache.EventTypeCreate, pod})
+		case prev.GetResourceVersion() != pod.GetResourceVersion():
+			events = append(events, podEvent{kcache.EventTypeUpdate, pod})
+		}
+	}
+
+	// deletions first, then by id, so that the order is stable.
+	sort.Slice(events, func(i, j int) bool {
+		a, b := events[i], events[j]
+		if (a.Type() == kcache.EventTypeDelete) != (b.Type() == kcache.EventTypeDelete) {
+			return a.Type() == kcache.EventTypeDelete
+		}
+		return nsname.ForObject(a.Resource()).String() < nsname.ForObject(b.Resource()).String()
+	})
+
+	for _, ev := range events {
+		if !f.send(ev) {
+			return current, false
+		}
+	}
+	return current, true
+}
+
+func (f *podFeed) send(ev pod.Event) bool {
+	select {
+	case f.eventch <- ev:
+		return true
+	case <-f.closech:
+		return false
+	}
+}
+
+// podFeedCache reads the cache of a feed's current subscription.
+type podFeedCache struct {
+	f *podFeed
+}
+
+func (c podFeedCache) List() ([]*v1.Pod, error) {
+	return c.f.current().Cache().List()
+}
+
+func (c podFeedCache) Get(ns, name string) (*v1.Pod, error) {
+	return c.f.current().Cache().Get(ns, name)
+}
+
+// podEvent is a pod event synthesized by a feed.
+type podEvent struct {
+	eventType kcache.EventType
+	pod       *v1.Pod
+}
+
+func (ev podEvent) Type() kcache.EventType {
+	return ev.eventType
+}
+
+func (ev podEvent) Resource() *v1.Pod {
+	return ev.pod
+}
+
+// subscribeDSPods subscribes to the pods matched by ds, following Refresh
+// and Reconfigure where ds supports them.
+func subscribeDSPods(ds DS) (pod.Subscription, error) {
+	if ds, ok := ds.(*datastore); ok {
+		return ds.subscribePods()
+	}
+	return ds.Pods().Subscribe()
+}
@@ -0,0 +1,151 @@
+package kail
+
+import (
+	"sync"
+
+	"github.com/boz/kcache/nsname"
+	"k8s.io/api/core/v1"
+)
+
+// podHandlers calls a datastore's pod handlers on a goroutine of their own.
+// Changes that arrive while a handler is running are coalesced per pod, so
+// the backlog is bounded by the number of matched pods and a slow handler
+// never stalls the datastore.  Handlers see each pod's net change: a pod
+// added and removed while they were busy is not reported at all, and one
+// replaced by another of the same name is reported as removed and added.
+type podHandlers struct {
+	onAdd    []func(*v1.Pod)
+	onRemove []func(*v1.Pod)
+
+	pending map[nsname.NSName]podChange
+	order   []nsname.NSName
+	mu      sync.Mutex
+
+	signalch chan struct{}
+	endch    chan struct{}
+	endOnce  sync.Once
+	donech   chan struct{}
+
+	// delivered holds the pods the handlers were last told were added.
+	delivered map[nsname.NSName]*v1.Pod
+}
+
+// podChange is the latest state of a pod with changes pending.
+type podChange struct {
+	pod     *v1.Pod
+	removed bool
+}
+
+func newPodHandlers(onAdd, onRemove []func(*v1.Pod)) *podHandlers {
+	h := &podHandlers{
+		onAdd:     onAdd,
+		onRemove:  onRemove,
+		pending:   make(map[nsname.NSName]podChange),
+		signalch:  make(chan struct{}, 1),
+		endch:     make(chan struct{}),
+		donech:    make(chan struct{}),
+		delivered: make(map[nsname.NSName]*v1.Pod),
+	}
+	go h.run()
+	return h
+}
+
+// added queues the addition of pod.  Like removed, it does nothing if h is
+// nil, as it is when no handlers are registered.
+func (h *podHandlers) added(pod *v1.Pod) {
+	if h != nil {
+		h.queue(podChange{pod: pod})
+	}
+}
+
+func (h *podHandlers) removed(pod *v1.Pod) {
+	if h != nil {
+		h.queue(podChange{pod: pod, removed: true})
+	}
+}
+
+// end stops the handlers once the changes already queued are delivered.
+func (h *podHandlers) end() {
+	h.endOnce.Do(func() { close(h.endch) })
+}
+
+func (h *podHandlers) done() <-chan struct{} {
+	return h.donech
+}
+
+func (h *podHandlers) queue(change podChange) {
+	id := nsname.ForObject(change.pod)
+
+	h.mu.Lock()
+	if _, ok := h.pending[id]; !ok {
+		h.order = append(h.order, id)
+	}
+	h.pending[id] = change
+	h.mu.Unlock()
+
+	select {
+	case h.signalch <- struct{}{}:
+	default:
+	}
+}
+
+func (h *podHandlers) run() {
+	defer close(h.donech)
+	for {
+		select {
+		case <-h.signalch:
+			h.flush()
+		case <-h.endch:
+			h.flush()
+			return
+		}
+	}
+}
+
+func (h *podHandlers) flush() {
+	for {
+		id, change, ok := h.next()
+		if !ok {
+			return
+		}
+		h.deliver(id, change)
+	}
+}
+
+func (h *podHandlers) next() (nsname.NSName, podChange, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.order) == 0 {
+		return nsname.NSName{}, podChange{}, false
+	}
+	id := h.order[0]
+	h.order = h.order[1:]
+	change := h.pending[id]
+	delete(h.pending, id)
+	return id, change, true
+}
+
+func (h *podHandlers) deliver(id nsname.NSName, change podChange) {
+	prev, found := h.delivered[id]
+	if found && (change.removed || prev.UID != change.pod.UID) {
+		delete(h.delivered, id)
+		removed := prev
+		if change.removed && prev.UID == change.pod.UID {
+			removed = change.pod
+		}
+		for _, fn := range h.onRemove {
+			fn(removed)
+		}
+		found = false
+	}
+	switch {
+	case found && !change.removed:
+		h.delivered[id] = change.pod
+	case !found && !change.removed:
+		h.delivered[id] = change.pod
+		for _, fn := range h.onAdd {
+			fn(change.pod)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package kail
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestPodHandlersSlowHandler(t *testing.T) {
+	withUID := func(name string, uid types.UID) *v1.Pod {
+		pod := testPod("default", name, nil)
+		pod.UID = uid
+		return pod
+	}
+
+	var mu sync.Mutex
+	var got []string
+	record := func(op string) func(*v1.Pod) {
+		return func(pod *v1.Pod) {
+			mu.Lock()
+			defer mu.Unlock()
+			got = append(got, fmt.Sprintf("%v %v/%v", op, pod.Name, pod.UID))
+		}
+	}
+
+	releasech := make(chan struct{})
+	blockedch := make(chan struct{})
+	var blockOnce sync.Once
+	block := func(*v1.Pod) {
+		blockOnce.Do(func() {
+			close(blockedch)
+			<-releasech
+		})
+	}
+
+	h := newPodHandlers(
+		[]func(*v1.Pod){record("add"), block},
+		[]func(*v1.Pod){record("remove")})
+
+	h.added(withUID("replaced", "1"))
+	select {
+	case <-blockedch:
+	case <-time.After(testTimeout):
+		t.Fatalf("handler not called")
+	}
+
+	// queueing must not wait for the blocked handler.
+	queuedch := make(chan struct{})
+	go func() {
+		defer close(queuedch)
+		h.removed(withUID("replaced", "1"))
+		h.added(withUID("replaced", "2"))
+		for i := 0; i < dsEventBufsiz*4; i++ {
+			pod := withUID(fmt.Sprintf("pod-%03d", i), "1")
+			h.added(pod)
+			if i%2 == 0 {
+				h.removed(pod)
+			}
+		}
+	}()
+	select {
+	case <-queuedch:
+	case <-time.After(testTimeout):
+		t.Fatalf("queueing stalled by a blocked handler")
+	}
+
+	close(releasech)
+	h.end()
+	select {
+	case <-h.done():
+	case <-time.After(testTimeout):
+		t.Fatalf("handlers not done")
+	}
+
+	want := []string{"add replaced/1", "remove replaced/1", "add replaced/2"}
+	var odd []string
+	for i := 1; i < dsEventBufsiz*4; i += 2 {
+		odd = append(odd, fmt.Sprintf("add pod-%03d/1", i))
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(got) < len(want) || !equalStrings(got[:len(want)], want) {
+		t.Fatalf("replaced pod: got %v, want %v first", got, want)
+	}
+	rest := append([]string(nil), got[len(want):]...)
+	sort.Strings(rest)
+	if !equalStrings(rest, odd) {
+		t.Errorf("coalesced pods: got %v, want %v", rest, odd)
+	}
+}
@@ -0,0 +1,482 @@
+package kail
+
+import (
+	"context"
+	"fmt"
+
+	logutil "github.com/boz/go-logutil"
+	"github.com/boz/kcache/filter"
+	"github.com/boz/kcache/join"
+	"github.com/boz/kcache/nsname"
+	"github.com/boz/kcache/types/daemonset"
+	"github.com/boz/kcache/types/deployment"
+	"github.com/boz/kcache/types/ingress"
+	"github.com/boz/kcache/types/node"
+	"github.com/boz/kcache/types/pod"
+	"github.com/boz/kcache/types/replicaset"
+	"github.com/boz/kcache/types/replicationcontroller"
+	"github.com/boz/kcache/types/service"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// The stages below narrow a chain's pods by criteria that require watching
+// other resources.  Each stage is built in two steps: its base controllers
+// are created, concurrently with those of the other stages, and then the
+// stage is wired into the chain's pods in order.
+//
+// A stage takes ownership of its bases.  It either succeeds, updating the
+// chain, or fails having closed every controller it created, leaving the
+// chain as it was.
+
+type stageBases struct {
+	nodes       node.Controller
+	services    service.Controller
+	rcs         replicationcontroller.Controller
+	rss         replicaset.Controller
+	dss         daemonset.Controller
+	deployments deployment.Controller
+	ingresses   ingress.Controller
+
+	// revisionRSs holds every replica set, whichever deployment owns it.
+	revisionRSs replicaset.Controller
+
+	// dcRCs is created for deployment configs only when there are no rc
+	// criteria; otherwise they share the rc base controller.
+	dcRCs replicationcontroller.Controller
+
+	// ingressServices is created for ingresses only when there are no
+	// service criteria; otherwise ingresses are joined with the matched
+	// services.
+	ingressServices service.Controller
+}
+
+// close closes the bases that have not been taken by a stage.
+func (bases *stageBases) close() {
+	closeAndWait(
+		bases.nodes, bases.services, bases.rcs, bases.rss, bases.dss,
+		bases.deployments, bases.ingresses, bases.revisionRSs, bases.dcRCs,
+		bases.ingressServices)
+}
+
+type stageBaseFn func(context.Context, logutil.Log, kubernetes.Interface, *stageBases) error
+type stageFn func(context.Context, *dsChain, *stageBases) error
+
+func (b *dsBuilder) createNodeBase(
+	ctx context.Context, log logutil.Log, cs kubernetes.Interface, bases *stageBases) error {
+	return b.retry(ctx, log, "node base controller", func() (err error) {
+		bases.nodes, err = node.NewController(ctx, log, cs, "")
+		return err
+	})
+}
+
+func (b *dsBuilder) createNodeStage(ctx context.Context, c *dsChain, bases *stageBases) error {
+	base := bases.nodes
+	bases.nodes = nil
+
+	var filters []filter.Filter
+	for _, selector := range b.nodeLabels {
+		filters = append(filters, filter.Selector(selector))
+	}
+	if b.nodeReady != nil {
+		filters = append(filters, nodeReadyFilter(*b.nodeReady))
+	}
+
+	nodes, err := base.CloneWithFilter(filter.And(filters...))
+	if err != nil {
+		closeAndWait(base)
+		return fmt.Errorf("node controller: %v", err)
+	}
+
+	sub, err := nodes.Subscribe()
+	if err != nil {
+		closeAndWait(base)
+		return fmt.Errorf("node subscription: %v", err)
+	}
+
+	pods, err := c.pods.CloneWithFilter(newIDFilter(nil))
+	if err != nil {
+		sub.Close()
+		closeAndWait(base)
+		return fmt.Errorf("node pod controller: %v", err)
+	}
+
+	c.nodesBase, c.nodes = base, nodes
+	c.setPods(pods)
+
+	c.addStage("node selector", pods, nodeChanges(sub), func() (filter.Filter, error) {
+		return nodeSelectorFilter(sub.Cache())
+	})
+	return nil
+}
+
+// nodeSelectorFilter accepts pods scheduled on any of the cached nodes.
+func nodeSelectorFilter(cache node.CacheReader) (filter.Filter, error) {
+	nodes, err := cache.List()
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) == 0 {
+		return newIDFilter(nil), nil
+	}
+	names := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		names = append(names, node.GetName())
+	}
+	return pod.NodeFilter(names...), nil
+}
+
+func (b *dsBuilder) createServiceBase(
+	ctx context.Context, log logutil.Log, cs kubernetes.Interface, bases *stageBases) error {
+	return b.retry(ctx, log, "service base controller", func() (err error) {
+		bases.services, err = service.NewController(ctx, log, cs, "")
+		return err
+	})
+}
+
+func (b *dsBuilder) createServiceStage(ctx context.Context, c *dsChain, bases *stageBases) error {
+	base := bases.services
+	bases.services = nil
+
+	services, err := base.CloneWithFilter(b.serviceFilter())
+	if err != nil {
+		closeAndWait(base)
+		return fmt.Errorf("service controller: %v", err)
+	}
+
+	pods, err := join.ServicePods(ctx, services, c.pods)
+	if err != nil {
+		closeAndWait(base)
+		return fmt.Errorf("service join: %v", err)
+	}
+
+	c.servicesBase, c.services = base, services
+	c.setPods(pods)
+	return nil
+}
+
+func (b *dsBuilder) serviceFilter() filter.Filter {
+	var filters []filter.Filter
+	if len(b.services) != 0 {
+		filters = append(filters, filter.NSName(b.services...))
+	}
+	if len(b.serviceTypes) != 0 {
+		filters = append(filters, serviceTypeFilter(b.serviceTypes))
+	}
+	return filter.And(filters...)
+}
+
+func (b *dsBuilder) createRCBase(
+	ctx context.Context, log logutil.Log, cs kubernetes.Interface, bases *stageBases) error {
+	return b.retry(ctx, log, "rc base controller", func() (err error) {
+		bases.rcs, err = replicationcontroller.NewController(ctx, log, cs, "")
+		return err
+	})
+}
+
+func (b *dsBuilder) createRCStage(ctx context.Context, c *dsChain, bases *stageBases) error {
+	base := bases.rcs
+	bases.rcs = nil
+
+	rcs, err := base.CloneWithFilter(filter.NSName(b.rcs...))
+	if err != nil {
+		closeAndWait(base)
+		return fmt.Errorf("rc controller: %v", err)
+	}
+
+	pods, err := join.RCPods(ctx, rcs, c.pods)
+	if err != nil {
+		closeAndWait(base)
+		return fmt.Errorf("rc join: %v", err)
+	}
+
+	c.rcsBase, c.rcs = base, rcs
+	c.setPods(pods)
+	return nil
+}
+
+func (b *dsBuilder) createRSBase(
+	ctx context.Context, log logutil.Log, cs kubernetes.Interface, bases *stageBases) error {
+	return b.retry(ctx, log, "rs base controller", func() (err error) {
+		bases.rss, err = replicaset.NewController(ctx, log, cs, "")
+		return err
+	})
+}
+
+func (b *dsBuilder) createRSStage(ctx context.Context, c *dsChain, bases *stageBases) error {
+	base := bases.rss
+	bases.rss = nil
+
+	rss, err := base.CloneWithFilter(filter.NSName(b.rss...))
+	if err != nil {
+		closeAndWait(base)
+		return fmt.Errorf("rs controller: %v", err)
+	}
+
+	pods, err := join.RSPods(ctx, rss, c.pods)
+	if err != nil {
+		closeAndWait(base)
+		return fmt.Errorf("rs join: %v", err)
+	}
+
+	c.rssBase, c.rss = base, rss
+	c.setPods(pods)
+	return nil
+}
+
+func (b *dsBuilder) createDSBase(
+	ctx context.Context, log logutil.Log, cs kubernetes.Interface, bases *stageBases) error {
+	return b.retry(ctx, log, "ds base controller", func() (err error) {
+		bases.dss, err = daemonset.NewController(ctx, log, cs, "")
+		return err
+	})
+}
+
+func (b *dsBuilder) createDSStage(ctx context.Context, c *dsChain, bases *stageBases) error {
+	base := bases.dss
+	bases.dss = nil
+
+	dss, err := base.CloneWithFilter(filter.NSName(b.dss...))
+	if err != nil {
+		closeAndWait(base)
+		return fmt.Errorf("ds controller: %v", err)
+	}
+
+	pods, err := join.DaemonSetPods(ctx, dss, c.pods)
+	if err != nil {
+		closeAndWait(base)
+		return fmt.Errorf("ds join: %v", err)
+	}
+
+	c.dssBase, c.dss = base, dss
+	c.setPods(pods)
+	return nil
+}
+
+func (b *dsBuilder) createDeploymentBase(
+	ctx context.Context, log logutil.Log, cs kubernetes.Interface, bases *stageBases) error {
+	return b.retry(ctx, log, "deployment base controller", func() (err error) {
+		bases.deployments, err = deployment.NewController(ctx, log, cs, "")
+		return err
+	})
+}
+
+func (b *dsBuilder) createDeploymentStage(ctx context.Context, c *dsChain, bases *stageBases) error {
+	base := bases.deployments
+	bases.deployments = nil
+
+	deployments, err := base.CloneWithFilter(filter.NSName(b.deployments...))
+	if err != nil {
+		closeAndWait(base)
+		return fmt.Errorf("deployment controller: %v", err)
+	}
+
+	pods, err := join.DeploymentPods(ctx, deployments, c.pods)
+	if err != nil {
+		closeAndWait(base)
+		return fmt.Errorf("deployment join: %v", err)
+	}
+
+	c.deploymentsBase, c.deployments = base, deployments
+	c.setPods(pods)
+	return nil
+}
+
+func (b *dsBuilder) createRevisionsBase(
+	ctx context.Context, log logutil.Log, cs kubernetes.Interface, bases *stageBases) error {
+	return b.retry(ctx, log, "rs base controller", func() (err error) {
+		bases.revisionRSs, err = replicaset.NewController(ctx, log, cs, "")
+		return err
+	})
+}
+
+func (b *dsBuilder) createRevisionsStage(ctx context.Context, c *dsChain, bases *stageBases) error {
+	base := bases.revisionRSs
+	bases.revisionRSs = nil
+
+	sub, err := base.Subscribe()
+	if err != nil {
+		closeAndWait(base)
+		return fmt.Errorf("deployment revisions rs subscription: %v", err)
+	}
+
+	pods, err := c.pods.CloneWithFilter(newIDFilter(nil))
+	if err != nil {
+		sub.Close()
+		closeAndWait(base)
+		return fmt.Errorf("deployment revisions pod controller: %v", err)
+	}
+
+	c.revisionRSs = base
+	c.setPods(pods)
+
+	deployments := newIDFilter(b.deploymentRevs)
+	c.addStage("deployment revisions", pods, rsChanges(sub), func() (filter.Filter, error) {
+		return revisionsFilter(sub.Cache(), deployments)
+	})
+	return nil
+}
+
+// revisionsFilter accepts pods owned by the cached replica sets that are
+// owned by any of the given deployments.
+func revisionsFilter(cache replicaset.CacheReader, deployments idFilter) (filter.Filter, error) {
+	rss, err := cache.List()
+	if err != nil {
+		return nil, err
+	}
+	owners := make(map[nsname.NSName]bool)
+	for _, rs := range rss {
+		for _, ref := range rs.GetOwnerReferences() {
+			if ref.Kind == "Deployment" && deployments[nsname.New(rs.GetNamespace(), ref.Name)] {
+				owners[nsname.ForObject(rs)] = true
+			}
+		}
+	}
+	return ownerFilter{"ReplicaSet", owners}, nil
+}
+
+// dcLabel is set by OpenShift on the replication controllers created for
+// a DeploymentConfig.
+const dcLabel = "openshift.io/deployment-config.name"
+
+func (b *dsBuilder) createDCBase(
+	ctx context.Context, log logutil.Log, cs kubernetes.Interface, bases *stageBases) error {
+	if len(b.rcs) != 0 {
+		return nil
+	}
+	return b.retry(ctx, log, "rc base controller", func() (err error) {
+		bases.dcRCs, err = replicationcontroller.NewController(ctx, log, cs, "")
+		return err
+	})
+}
+
+func (b *dsBuilder) createDCStage(ctx context.Context, c *dsChain, bases *stageBases) error {
+	rcsBase := bases.dcRCs
+	bases.dcRCs = nil
+
+	base := c.rcsBase
+	if rcsBase != nil {
+		base = rcsBase
+	}
+	if base == nil {
+		return fmt.Errorf("rc base controller unavailable")
+	}
+
+	rcs, err := base.CloneWithFilter(dcFilter(b.dcs))
+	if err != nil {
+		closeAndWait(rcsBase)
+		return fmt.Errorf("deployment config rc controller: %v", err)
+	}
+
+	pods, err := join.RCPods(ctx, rcs, c.pods)
+	if err != nil {
+		closeAndWait(rcsBase)
+		return fmt.Errorf("deployment config join: %v", err)
+	}
+
+	if rcsBase != nil {
+		c.rcsBase = rcsBase
+	}
+	c.dcRCs = rcs
+	c.setPods(pods)
+	return nil
+}
+
+// dcFilter accepts replication controllers belonging to the given
+// DeploymentConfigs.
+type dcFilter []nsname.NSName
+
+func (f dcFilter) Accept(obj metav1.Object) bool {
+	name, ok := obj.GetLabels()[dcLabel]
+	if !ok {
+		return false
+	}
+	for _, id := range f {
+		if id.Name == name && (id.Namespace == "" || id.Namespace == obj.GetNamespace()) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f dcFilter) Equals(other filter.Filter) bool {
+	o, ok := other.(dcFilter)
+	return ok && equalEach(len(f), len(o), func(i int) bool { return f[i] == o[i] })
+}
+
+func (b *dsBuilder) createIngressBase(
+	ctx context.Context, log logutil.Log, cs kubernetes.Interface, bases *stageBases) error {
+
+	var base ingress.Controller
+	err := b.retry(ctx, log, "ingress base controller", func() (err error) {
+		base, err = ingress.NewController(ctx, log, cs, "")
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(b.services) == 0 && len(b.serviceTypes) == 0 {
+		err = b.retry(ctx, log, "service base controller", func() (err error) {
+			bases.ingressServices, err = service.NewController(ctx, log, cs, "")
+			return err
+		})
+		if err != nil {
+			closeAndWait(base)
+			return err
+		}
+	}
+
+	bases.ingresses = base
+	return nil
+}
+
+func (b *dsBuilder) createIngressStage(ctx context.Context, c *dsChain, bases *stageBases) error {
+	base, servicesBase := bases.ingresses, bases.ingressServices
+	bases.ingresses, bases.ingressServices = nil, nil
+
+	// ingresses are joined against the services matched so far, or every
+	// service if there are no service criteria.
+	services := c.services
+	if servicesBase != nil {
+		services = servicesBase
+	}
+	if services == nil {
+		closeAndWait(base)
+		return fmt.Errorf("service controller unavailable")
+	}
+
+	ingresses, err := base.CloneWithFilter(filter.NSName(b.ingresses...))
+	if err != nil {
+		closeAndWait(base, servicesBase)
+		return fmt.Errorf("ingresses controller: %v", err)
+	}
+
+	pods, err := join.IngressPods(ctx, ingresses, services, c.pods)
+	if err != nil {
+		closeAndWait(base, servicesBase)
+		return fmt.Errorf("ingress join: %v", err)
+	}
+
+	if servicesBase != nil {
+		c.servicesBase, c.services = servicesBase, servicesBase
+	}
+	c.ingressesBase, c.ingresses = base, ingresses
+	c.setPods(pods)
+	return nil
+}
+
+// closeAndWait closes the given controllers, ignoring nil ones, and blocks
+// until they have completed.
+func closeAndWait(controllers ...cacheController) {
+	for _, c := range controllers {
+		if c != nil {
+			c.Close()
+		}
+	}
+	for _, c := range controllers {
+		if c != nil {
+			<-c.Done()
+		}
+	}
+}
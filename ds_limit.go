@@ -0,0 +1,52 @@
+package kail
+
+import (
+	"sort"
+
+	logutil "github.com/boz/go-logutil"
+	"github.com/boz/kcache/filter"
+	"github.com/boz/kcache/nsname"
+	"github.com/boz/kcache/types/pod"
+)
+
+// podLimiter restricts the matched set to the most recently created pods.
+// When more pods match than the limit allows, the oldest are dropped and a
+// warning is logged each time the number dropped changes.
+type podLimiter struct {
+	limit   int
+	sub     pod.Subscription
+	dropped int
+	log     logutil.Log
+}
+
+func (l *podLimiter) filter() (filter.Filter, error) {
+	pods, err := l.sub.Cache().List()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(pods, func(a, b int) bool {
+		ta := pods[a].GetCreationTimestamp()
+		tb := pods[b].GetCreationTimestamp()
+		return tb.Time.Before(ta.Time)
+	})
+
+	dropped := 0
+	if len(pods) > l.limit {
+		dropped = len(pods) - l.limit
+		pods = pods[:l.limit]
+	}
+
+	if dropped != l.dropped {
+		if dropped > 0 {
+			l.log.Warnf("pod limit (%v) exceeded: dropping %v oldest pods", l.limit, dropped)
+		}
+		l.dropped = dropped
+	}
+
+	ids := make([]nsname.NSName, 0, len(pods))
+	for _, pod := range pods {
+		ids = append(ids, nsname.ForObject(pod))
+	}
+	return newIDFilter(ids), nil
+}
@@ -0,0 +1,111 @@
+package kail
+
+import (
+	"context"
+	"time"
+
+	logutil "github.com/boz/go-logutil"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+const listWatchRetryDelay = time.Second
+
+// listWatchSource is a resource that kcache has no controller for, whose
+// state is kept by a listWatcher.
+type listWatchSource interface {
+	// list replaces the state with the current objects, returning the
+	// list's resource version.
+	list() (string, error)
+
+	// watch opens a watch from the given resource version.
+	watch(rv string) (watch.Interface, error)
+
+	// apply applies ev to the state, reporting whether it changed.
+	apply(ev watch.Event) bool
+}
+
+// listWatcher lists and then watches a source, relisting whenever the watch
+// ends, until closech is closed.  changes is signalled after each list and
+// after each event that changes the source's state.
+type listWatcher struct {
+	src     listWatchSource
+	name    string
+	closech <-chan struct{}
+	clock   clock.Clock
+	changes chan<- struct{}
+	log     logutil.Log
+}
+
+// initial performs the first list, retrying as b allows, and returns its
+// resource version, from which run continues.
+func (lw *listWatcher) initial(ctx context.Context, b *dsBuilder) (string, error) {
+	var rv string
+	err := b.retry(ctx, lw.log, lw.name+" list", func() (err error) {
+		rv, err = lw.list()
+		return err
+	})
+	return rv, err
+}
+
+// run watches from the given resource version until closech is closed.
+func (lw *listWatcher) run(rv string) {
+	for {
+		if err := lw.watch(rv); err != nil {
+			lw.log.ErrWarn(err, "watching %v", lw.name)
+		}
+
+		for {
+			select {
+			case <-lw.closech:
+				return
+			case <-lw.clock.After(listWatchRetryDelay):
+			}
+
+			var err error
+			if rv, err = lw.list(); err == nil {
+				break
+			}
+			lw.log.ErrWarn(err, "listing %v", lw.name)
+		}
+	}
+}
+
+func (lw *listWatcher) list() (string, error) {
+	rv, err := lw.src.list()
+	if err == nil {
+		lw.signal()
+	}
+	return rv, err
+}
+
+// watch applies events to the source's state until the watch ends or
+// closech is closed.
+func (lw *listWatcher) watch(rv string) error {
+	watcher, err := lw.src.watch(rv)
+	if err != nil {
+		return err
+	}
+	defer watcher.Stop()
+
+	for {
+		select {
+		case <-lw.closech:
+			return nil
+		case ev, ok := <-watcher.ResultChan():
+			if !ok || ev.Type == watch.Error {
+				return nil
+			}
+			if lw.src.apply(ev) {
+				lw.signal()
+			}
+		}
+	}
+}
+
+func (lw *listWatcher) signal() {
+	select {
+	case lw.changes <- struct{}{}:
+	default:
+	}
+}
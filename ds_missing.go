@@ -0,0 +1,65 @@
+package kail
+
+import (
+	"github.com/boz/kcache/nsname"
+)
+
+// warnMissing logs a warning for each resource named in the criteria that
+// does not exist once the chain is ready.  Such criteria are usually typos,
+// and otherwise silently match nothing.
+func (c *dsChain) warnMissing() {
+	b := c.builder()
+
+	check := func(kind string, ids []nsname.NSName, get func(ns, name string) (bool, error)) {
+		for _, id := range ids {
+			found, err := get(id.Namespace, id.Name)
+			switch {
+			case err != nil:
+				c.log.ErrWarn(err, "checking %v %v/%v", kind, id.Namespace, id.Name)
+			case !found:
+				c.log.Warnf("%v %v/%v not found", kind, id.Namespace, id.Name)
+			}
+		}
+	}
+
+	check("pod", b.pods, func(ns, name string) (bool, error) {
+		obj, err := c.podBase.Cache().Get(ns, name)
+		return obj != nil, err
+	})
+	if c.servicesBase != nil {
+		check("service", b.services, func(ns, name string) (bool, error) {
+			obj, err := c.servicesBase.Cache().Get(ns, name)
+			return obj != nil, err
+		})
+	}
+	if c.rcsBase != nil {
+		check("rc", b.rcs, func(ns, name string) (bool, error) {
+			obj, err := c.rcsBase.Cache().Get(ns, name)
+			return obj != nil, err
+		})
+	}
+	if c.rssBase != nil {
+		check("rs", b.rss, func(ns, name string) (bool, error) {
+			obj, err := c.rssBase.Cache().Get(ns, name)
+			return obj != nil, err
+		})
+	}
+	if c.dssBase != nil {
+		check("ds", b.dss, func(ns, name string) (bool, error) {
+			obj, err := c.dssBase.Cache().Get(ns, name)
+			return obj != nil, err
+		})
+	}
+	if c.deploymentsBase != nil {
+		check("deployment", b.deployments, func(ns, name string) (bool, error) {
+			obj, err := c.deploymentsBase.Cache().Get(ns, name)
+			return obj != nil, err
+		})
+	}
+	if c.ingressesBase != nil {
+		check("ingress", b.ingresses, func(ns, name string) (bool, error) {
+			obj, err := c.ingressesBase.Cache().Get(ns, name)
+			return obj != nil, err
+		})
+	}
+}
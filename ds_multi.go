@@ -0,0 +1,255 @@
+package kail
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	logutil "github.com/boz/go-logutil"
+	"github.com/boz/kcache/nsname"
+	"k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// MultiDS combines datastores built from the same criteria against several
+// clusters.  Pods are qualified by the name of the cluster they came from.
+type MultiDS interface {
+	Clusters() []string
+	Cluster(name string) DS
+
+	// Pods is the unified view of the pods matched in every cluster.
+	Pods() ClusterPods
+
+	// Ready is closed once every cluster's datastore is ready.  If any of
+	// them shuts down first, all of them are closed and Ready is never
+	// closed.
+	Ready() <-chan struct{}
+
+	// Done is closed once every cluster's datastore is done.
+	Done() <-chan struct{}
+	Close()
+}
+
+type ClusterPod struct {
+	Cluster string
+	Pod     *v1.Pod
+}
+
+// ClusterPods is the set of pods matched across the clusters of a MultiDS.
+type ClusterPods interface {
+	List() ([]ClusterPod, error)
+
+	// Get returns the matched pod with the given id in the named cluster.
+	Get(cluster string, id nsname.NSName) (*v1.Pod, bool)
+
+	// Subscribe delivers changes to the pods matched in any cluster, as
+	// DS.Subscribe does for one.  The added events for every cluster's
+	// initial pods are followed by a single DSEventInitialListComplete
+	// event, with no cluster, once all clusters have sent theirs.
+	Subscribe() ClusterSubscription
+}
+
+// ClusterEvent is a change to the pods matched in the named cluster.
+type ClusterEvent struct {
+	Cluster string
+	DSEvent
+}
+
+type ClusterSubscription interface {
+	// Events is closed once the subscription or every cluster's datastore
+	// is closed.
+	Events() <-chan ClusterEvent
+	Close()
+}
+
+func (b *dsBuilder) CreateMulti(ctx context.Context, clients map[string]kubernetes.Interface) (MultiDS, error) {
+	log := b.logger(ctx).WithComponent("kail.ds.multi")
+
+	if b.podBase != nil {
+		return nil, log.Err(errors.New("shared pod controller"), "multi-cluster datastore")
+	}
+
+	m := &multiDatastore{
+		datastores: make(map[string]DS, len(clients)),
+		readych:    make(chan struct{}),
+		donech:     make(chan struct{}),
+		log:        log,
+	}
+
+	for name, cs := range clients {
+		m.names = append(m.names, name)
+		ds, err := b.Create(ctx, cs)
+		if err != nil {
+			m.abort()
+			return nil, log.Err(err, "cluster %v", name)
+		}
+		m.datastores[name] = ds
+	}
+
+	sort.Strings(m.names)
+
+	go m.waitReadyAll()
+	go m.waitDoneAll()
+
+	return m, nil
+}
+
+type multiDatastore struct {
+	names      []string
+	datastores map[string]DS
+
+	readych   chan struct{}
+	donech    chan struct{}
+	closeOnce sync.Once
+	log       logutil.Log
+}
+
+func (m *multiDatastore) Clusters() []string {
+	return append([]string(nil), m.names...)
+}
+
+func (m *multiDatastore) Cluster(name string) DS {
+	return m.datastores[name]
+}
+
+func (m *multiDatastore) Pods() ClusterPods {
+	return clusterPods{m}
+}
+
+func (m *multiDatastore) Ready() <-chan struct{} {
+	return m.readych
+}
+
+func (m *multiDatastore) Done() <-chan struct{} {
+	return m.donech
+}
+
+func (m *multiDatastore) Close() {
+	m.closeOnce.Do(func() {
+		for _, ds := range m.datastores {
+			ds.Close()
+		}
+	})
+}
+
+func (m *multiDatastore) abort() {
+	m.Close()
+	m.waitDoneAll()
+}
+
+func (m *multiDatastore) waitReadyAll() {
+	for name, ds := range m.datastores {
+		select {
+		case <-ds.Done():
+			m.log.Warnf("cluster %v done before ready", name)
+			m.Close()
+			return
+		case <-ds.Ready():
+		}
+	}
+	close(m.readych)
+}
+
+func (m *multiDatastore) waitDoneAll() {
+	defer close(m.donech)
+	for _, ds := range m.datastores {
+		<-ds.Done()
+	}
+}
+
+type clusterPods struct {
+	m *multiDatastore
+}
+
+func (p clusterPods) List() ([]ClusterPod, error) {
+	var pods []ClusterPod
+	for _, name := range p.m.names {
+		list, err := p.m.datastores[name].Pods().Cache().List()
+		if err != nil {
+			return nil, err
+		}
+		for _, pod := range list {
+			pods = append(pods, ClusterPod{name, pod})
+		}
+	}
+	return pods, nil
+}
+
+func (p clusterPods) Get(cluster string, id nsname.NSName) (*v1.Pod, bool) {
+	ds, ok := p.m.datastores[cluster]
+	if !ok {
+		return nil, false
+	}
+	return ds.Get(id)
+}
+
+func (p clusterPods) Subscribe() ClusterSubscription {
+	s := &clusterSubscription{
+		ch:      make(chan ClusterEvent, dsEventBufsiz),
+		closech: make(chan struct{}),
+		pending: int32(len(p.m.names)),
+	}
+
+	var wg sync.WaitGroup
+	for _, name := range p.m.names {
+		sub := p.m.datastores[name].Subscribe()
+		s.subs = append(s.subs, sub)
+		wg.Add(1)
+		go func(name string, sub DSSubscription) {
+			defer wg.Done()
+			s.forward(name, sub)
+		}(name, sub)
+	}
+
+	go func() {
+		wg.Wait()
+		close(s.ch)
+	}()
+
+	return s
+}
+
+// clusterSubscription merges the subscriptions of each cluster's
+// datastore.
+type clusterSubscription struct {
+	subs      []DSSubscription
+	ch        chan ClusterEvent
+	closech   chan struct{}
+	closeOnce sync.Once
+
+	// pending is the number of clusters yet to complete their initial
+	// list.
+	pending int32
+}
+
+func (s *clusterSubscription) Events() <-chan ClusterEvent {
+	return s.ch
+}
+
+func (s *clusterSubscription) Close() {
+	s.closeOnce.Do(func() {
+		close(s.closech)
+		for _, sub := range s.subs {
+			sub.Close()
+		}
+	})
+}
+
+func (s *clusterSubscription) forward(name string, sub DSSubscription) {
+	for ev := range sub.Events() {
+		cev := ClusterEvent{name, ev}
+		if ev.Type == DSEventInitialListComplete {
+			if atomic.AddInt32(&s.pending, -1) != 0 {
+				continue
+			}
+			cev.Cluster = ""
+		}
+		select {
+		case s.ch <- cev:
+		case <-s.closech:
+			return
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package kail
+
+import (
+	"context"
+	"sync"
+
+	logutil "github.com/boz/go-logutil"
+	"github.com/boz/kcache/filter"
+	"github.com/boz/kcache/nsname"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+)
+
+// namespaceWatcher tracks the active namespaces matching a selector.
+// Namespaces leave the set as soon as they begin terminating.
+//
+// kcache has no namespace controller, so namespaces are listed and watched
+// directly.
+type namespaceWatcher struct {
+	cs       kubernetes.Interface
+	selector labels.Selector
+
+	// changes is signalled after each list and after each change to the
+	// set.  It is closed when the watcher stops.
+	changes chan struct{}
+
+	names map[string]bool
+	mu    sync.Mutex
+}
+
+// newNamespaceWatcher lists the namespaces matching b's namespace selector,
+// retrying as b allows, and then watches them until closech is closed.  It
+// fails if the namespaces cannot be listed.
+func newNamespaceWatcher(
+	ctx context.Context, b *dsBuilder, cs kubernetes.Interface,
+	closech <-chan struct{}, log logutil.Log) (*namespaceWatcher, error) {
+
+	w := &namespaceWatcher{
+		cs:       cs,
+		selector: b.nsSelector,
+		changes:  make(chan struct{}, 1),
+		names:    make(map[string]bool),
+	}
+
+	lw := &listWatcher{
+		src:     w,
+		name:    "namespace",
+		closech: closech,
+		clock:   b.clk(),
+		changes: w.changes,
+		log:     log.WithComponent("kail.ds.namespaces"),
+	}
+
+	rv, err := lw.initial(ctx, b)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		defer close(w.changes)
+		lw.run(rv)
+	}()
+	return w, nil
+}
+
+// filter accepts pods in the namespaces currently in the set, or in any of
+// the extra namespaces.
+func (w *namespaceWatcher) filter(extra []string) (filter.Filter, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	names := make([]string, 0, len(w.names)+len(extra))
+	for name := range w.names {
+		names = append(names, name)
+	}
+	return namespaceFilter(append(names, extra...)), nil
+}
+
+// namespaceFilter accepts pods in the given namespaces.
+func namespaceFilter(names []string) filter.Filter {
+	if len(names) == 0 {
+		return newIDFilter(nil)
+	}
+	ids := make([]nsname.NSName, 0, len(names))
+	for _, name := range names {
+		ids = append(ids, nsname.New(name, ""))
+	}
+	return filter.NSName(ids...)
+}
+
+func (w *namespaceWatcher) list() (string, error) {
+	opts := metav1.ListOptions{LabelSelector: w.selector.String()}
+	list, err := w.cs.CoreV1().Namespaces().List(opts)
+	if err != nil {
+		return "", err
+	}
+
+	names := make(map[string]bool, len(list.Items))
+	for i := range list.Items {
+		if ns := &list.Items[i]; isActiveNamespace(ns) {
+			names[ns.Name] = true
+		}
+	}
+
+	w.mu.Lock()
+	w.names = names
+	w.mu.Unlock()
+
+	return list.ResourceVersion, nil
+}
+
+func (w *namespaceWatcher) watch(rv string) (watch.Interface, error) {
+	opts := metav1.ListOptions{LabelSelector: w.selector.String(), ResourceVersion: rv}
+	return w.cs.CoreV1().Namespaces().Watch(opts)
+}
+
+func (w *namespaceWatcher) apply(ev watch.Event) bool {
+	ns, ok := ev.Object.(*v1.Namespace)
+	if !ok {
+		return false
+	}
+
+	active := ev.Type != watch.Deleted && isActiveNamespace(ns)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	changed := w.names[ns.Name] != active
+	if active {
+		w.names[ns.Name] = true
+	} else {
+		delete(w.names, ns.Name)
+	}
+	return changed
+}
+
+func isActiveNamespace(ns *v1.Namespace) bool {
+	return ns.DeletionTimestamp == nil && ns.Status.Phase != v1.NamespaceTerminating
+}
@@ -0,0 +1,200 @@
+package kail
+
+import (
+	"context"
+	"sync"
+
+	logutil "github.com/boz/go-logutil"
+	"github.com/boz/kcache/filter"
+	"github.com/boz/kcache/nsname"
+	"k8s.io/api/policy/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+)
+
+// pdbWatcher tracks the pod selectors of the named PodDisruptionBudgets.
+//
+// kcache has no PodDisruptionBudget controller, so budgets are listed and
+// watched directly, in each of the namespaces of the named budgets.
+type pdbWatcher struct {
+	cs  kubernetes.Interface
+	ids map[nsname.NSName]bool
+
+	// changes is signalled after each list and after each change to a
+	// selector.  It is closed when the watcher stops.
+	changes chan struct{}
+
+	selectors map[nsname.NSName]labels.Selector
+	mu        sync.Mutex
+
+	log logutil.Log
+}
+
+// newPDBWatcher lists the budgets in the namespaces of the named budgets,
+// retrying as b allows, and then watches them until closech is closed.  It
+// fails if any of the namespaces' budgets cannot be listed.
+func newPDBWatcher(
+	ctx context.Context, b *dsBuilder, cs kubernetes.Interface,
+	closech <-chan struct{}, log logutil.Log) (*pdbWatcher, error) {
+
+	w := &pdbWatcher{
+		cs:        cs,
+		ids:       newIDFilter(b.pdbs),
+		changes:   make(chan struct{}, 1),
+		selectors: make(map[nsname.NSName]labels.Selector),
+		log:       log.WithComponent("kail.ds.pdbs"),
+	}
+
+	var namespaces []string
+	seen := make(map[string]bool)
+	for _, id := range b.pdbs {
+		if !seen[id.Namespace] {
+			seen[id.Namespace] = true
+			namespaces = append(namespaces, id.Namespace)
+		}
+	}
+
+	lws := make([]*listWatcher, 0, len(namespaces))
+	rvs := make([]string, 0, len(namespaces))
+	for _, ns := range namespaces {
+		lw := &listWatcher{
+			src:     pdbNamespace{w, ns},
+			name:    "pod disruption budget",
+			closech: closech,
+			clock:   b.clk(),
+			changes: w.changes,
+			log:     w.log,
+		}
+		rv, err := lw.initial(ctx, b)
+		if err != nil {
+			return nil, err
+		}
+		lws = append(lws, lw)
+		rvs = append(rvs, rv)
+	}
+
+	var wg sync.WaitGroup
+	for i, lw := range lws {
+		wg.Add(1)
+		go func(lw *listWatcher, rv string) {
+			defer wg.Done()
+			lw.run(rv)
+		}(lw, rvs[i])
+	}
+	go func() {
+		wg.Wait()
+		close(w.changes)
+	}()
+
+	return w, nil
+}
+
+// filter accepts pods selected by any of the budgets, in the budget's
+// namespace.
+func (w *pdbWatcher) filter() (filter.Filter, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.selectors) == 0 {
+		return newIDFilter(nil), nil
+	}
+	filters := make([]filter.Filter, 0, len(w.selectors))
+	for id, selector := range w.selectors {
+		filters = append(filters, filter.And(
+			filter.NSName(nsname.New(id.Namespace, "")),
+			filter.Selector(selector)))
+	}
+	return filter.Or(filters...), nil
+}
+
+// pdbNamespace is the budgets of a single namespace, kept in w's
+// selectors.
+type pdbNamespace struct {
+	w  *pdbWatcher
+	ns string
+}
+
+// list replaces the namespace's selectors with those of its current
+// budgets.
+func (p pdbNamespace) list() (string, error) {
+	list, err := p.w.cs.PolicyV1beta1().PodDisruptionBudgets(p.ns).List(metav1.ListOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	selectors := make(map[nsname.NSName]labels.Selector)
+	for i := range list.Items {
+		pdb := &list.Items[i]
+		if selector := p.w.selector(pdb); selector != nil {
+			selectors[nsname.ForObject(pdb)] = selector
+		}
+	}
+
+	p.w.mu.Lock()
+	defer p.w.mu.Unlock()
+
+	for id := range p.w.selectors {
+		if id.Namespace == p.ns {
+			delete(p.w.selectors, id)
+		}
+	}
+	for id, selector := range selectors {
+		p.w.selectors[id] = selector
+	}
+	return list.ResourceVersion, nil
+}
+
+func (p pdbNamespace) watch(rv string) (watch.Interface, error) {
+	opts := metav1.ListOptions{ResourceVersion: rv}
+	return p.w.cs.PolicyV1beta1().PodDisruptionBudgets(p.ns).Watch(opts)
+}
+
+func (p pdbNamespace) apply(ev watch.Event) bool {
+	pdb, ok := ev.Object.(*v1beta1.PodDisruptionBudget)
+	if !ok {
+		return false
+	}
+	id := nsname.ForObject(pdb)
+	if !p.w.ids[id] {
+		return false
+	}
+
+	var selector labels.Selector
+	if ev.Type != watch.Deleted {
+		selector = p.w.selector(pdb)
+	}
+
+	p.w.mu.Lock()
+	defer p.w.mu.Unlock()
+
+	prev, found := p.w.selectors[id]
+	changed := found != (selector != nil) ||
+		(found && prev.String() != selector.String())
+	if selector != nil {
+		p.w.selectors[id] = selector
+	} else {
+		delete(p.w.selectors, id)
+	}
+	return changed
+}
+
+// selector returns the pod selector of pdb if it is one of the named
+// budgets and selects any pods.
+func (w *pdbWatcher) selector(pdb *v1beta1.PodDisruptionBudget) labels.Selector {
+	id := nsname.ForObject(pdb)
+	if !w.ids[id] || pdb.Spec.Selector == nil {
+		return nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+	if err != nil {
+		w.log.ErrWarn(err, "pod disruption budget %v/%v selector", id.Namespace, id.Name)
+		return nil
+	}
+	if selector.Empty() {
+		// an empty selector selects nothing in policy/v1beta1.
+		return nil
+	}
+	return selector
+}
@@ -0,0 +1,147 @@
+package kail
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/boz/kcache/nsname"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+)
+
+// podWatchLimit is the most pods named with WithPods that are listed and
+// watched one by one rather than through a watch of every namespace.  Each
+// costs a watch of its own, so past a handful one wide watch is cheaper.
+const podWatchLimit = 10
+
+// podsClient is a kcache client for the pods with the given ids only.  It
+// lists and watches each with a field selector on its name, in its
+// namespace.  The resource version of a list joins those of each pod's
+// list, so that each pod's watch resumes from its own.
+type podsClient struct {
+	cs  kubernetes.Interface
+	ids []nsname.NSName
+}
+
+func (c podsClient) List(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+	opts.ResourceVersion = ""
+
+	result := &v1.PodList{}
+	rvs := make([]string, 0, len(c.ids))
+	for _, id := range c.ids {
+		list, err := c.cs.CoreV1().Pods(id.Namespace).List(c.options(id, opts))
+		if err != nil {
+			return nil, err
+		}
+		for _, pod := range list.Items {
+			if pod.Name == id.Name {
+				result.Items = append(result.Items, pod)
+			}
+		}
+		rvs = append(rvs, list.ResourceVersion)
+	}
+	result.ResourceVersion = strings.Join(rvs, ",")
+	return result, nil
+}
+
+// Watch watches each pod from its resource version in opts, or from the
+// current state if opts holds none for them.
+func (c podsClient) Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+	rvs := strings.Split(opts.ResourceVersion, ",")
+	if len(rvs) != len(c.ids) {
+		rvs = make([]string, len(c.ids))
+	}
+
+	watches := make([]watch.Interface, 0, len(c.ids))
+	for i, id := range c.ids {
+		wopts := c.options(id, opts)
+		wopts.ResourceVersion = rvs[i]
+		w, err := c.cs.CoreV1().Pods(id.Namespace).Watch(wopts)
+		if err != nil {
+			for _, w := range watches {
+				w.Stop()
+			}
+			return nil, err
+		}
+		watches = append(watches, w)
+	}
+	return newPodsWatch(c.ids, watches), nil
+}
+
+func (c podsClient) options(id nsname.NSName, opts metav1.ListOptions) metav1.ListOptions {
+	opts.FieldSelector = fields.OneTermEqualSelector("metadata.name", id.Name).String()
+	return opts
+}
+
+// podsWatch merges the watches of a podsClient.  It ends as soon as any of
+// them does, so that the pods are relisted together.
+type podsWatch struct {
+	watches  []watch.Interface
+	resultch chan watch.Event
+	stopch   chan struct{}
+	stopOnce sync.Once
+}
+
+func newPodsWatch(ids []nsname.NSName, watches []watch.Interface) *podsWatch {
+	w := &podsWatch{
+		watches:  watches,
+		resultch: make(chan watch.Event),
+		stopch:   make(chan struct{}),
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(len(watches))
+	for i := range watches {
+		go func(id nsname.NSName, watcher watch.Interface) {
+			defer wg.Done()
+			defer w.Stop()
+			w.forward(id, watcher)
+		}(ids[i], watches[i])
+	}
+	go func() {
+		wg.Wait()
+		close(w.resultch)
+	}()
+
+	return w
+}
+
+func (w *podsWatch) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.stopch)
+		for _, watcher := range w.watches {
+			watcher.Stop()
+		}
+	})
+}
+
+func (w *podsWatch) ResultChan() <-chan watch.Event {
+	return w.resultch
+}
+
+// forward passes on the events of watcher about the pod named id.
+func (w *podsWatch) forward(id nsname.NSName, watcher watch.Interface) {
+	for {
+		select {
+		case <-w.stopch:
+			return
+		case ev, ok := <-watcher.ResultChan():
+			if !ok {
+				return
+			}
+			if pod, ok := ev.Object.(*v1.Pod); ok && pod.Name != id.Name {
+				continue
+			}
+			select {
+			case w.resultch <- ev:
+			case <-w.stopch:
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package kail
+
+import (
+	"fmt"
+
+	"github.com/boz/kcache/nsname"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func (ds *datastore) MatchReasons(id nsname.NSName) []string {
+	c := ds.chain()
+	pod, ok := c.get(id)
+	if !ok {
+		return nil
+	}
+	b := c.builder()
+
+	podLabels := labels.Set(pod.GetLabels())
+	var reasons []string
+
+	match := func(kind string, obj metav1.Object, selector labels.Selector) {
+		if obj.GetNamespace() != pod.GetNamespace() || selector == nil || selector.Empty() {
+			return
+		}
+		if selector.Matches(podLabels) {
+			reasons = append(reasons, fmt.Sprintf("%v %v/%v", kind, obj.GetNamespace(), obj.GetName()))
+		}
+	}
+	matchSelector := func(kind string, obj metav1.Object, ls *metav1.LabelSelector) {
+		if ls == nil {
+			return
+		}
+		selector, err := metav1.LabelSelectorAsSelector(ls)
+		if err != nil {
+			c.log.ErrWarn(err, "%v %v/%v selector", kind, obj.GetNamespace(), obj.GetName())
+			return
+		}
+		match(kind, obj, selector)
+	}
+
+	if c.nodes != nil && pod.Spec.NodeName != "" {
+		if node, err := c.nodes.Cache().Get("", pod.Spec.NodeName); err == nil && node != nil {
+			reasons = append(reasons, "node "+node.GetName())
+		}
+	}
+
+	if (len(b.services) != 0 || len(b.serviceTypes) != 0) && c.services != nil {
+		if svcs, err := c.services.Cache().List(); err == nil {
+			for _, svc := range svcs {
+				match("service", svc, labels.SelectorFromSet(svc.Spec.Selector))
+			}
+		}
+	}
+
+	if len(b.rcs) != 0 && c.rcs != nil {
+		if rcs, err := c.rcs.Cache().List(); err == nil {
+			for _, rc := range rcs {
+				match("rc", rc, labels.SelectorFromSet(rc.Spec.Selector))
+			}
+		}
+	}
+
+	if len(b.rss) != 0 && c.rss != nil {
+		if rss, err := c.rss.Cache().List(); err == nil {
+			for _, rs := range rss {
+				matchSelector("rs", rs, rs.Spec.Selector)
+			}
+		}
+	}
+
+	if len(b.dss) != 0 && c.dss != nil {
+		if dss, err := c.dss.Cache().List(); err == nil {
+			for _, d := range dss {
+				matchSelector("ds", d, d.Spec.Selector)
+			}
+		}
+	}
+
+	if len(b.deployments) != 0 && c.deployments != nil {
+		if deployments, err := c.deployments.Cache().List(); err == nil {
+			for _, d := range deployments {
+				matchSelector("deployment", d, d.Spec.Selector)
+			}
+		}
+	}
+
+	return reasons
+}
@@ -0,0 +1,57 @@
+package kail
+
+import (
+	"context"
+	"net"
+	"net/url"
+
+	logutil "github.com/boz/go-logutil"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// retry calls fn until it succeeds, returns an error that is not
+// retryable, or the configured attempts are exhausted.  The delay between
+// attempts starts at the configured backoff and doubles after each one.
+func (b *dsBuilder) retry(ctx context.Context, log logutil.Log, name string, fn func() error) error {
+	delay := b.retryBackoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= b.retryAttempts || !isRetryable(err) {
+			return err
+		}
+
+		log.Warnf("%v: attempt %v of %v failed, retrying in %v: %v",
+			name, attempt, b.retryAttempts, delay, err)
+
+		select {
+		case <-b.clk().After(delay):
+		case <-ctx.Done():
+			return err
+		}
+		delay *= 2
+	}
+}
+
+// isRetryable reports whether err is likely to be transient: a network
+// failure reaching the API server, or a server-side timeout or
+// unavailability.  Authorization and missing-resource errors are fatal.
+func isRetryable(err error) bool {
+	switch {
+	case errors.IsForbidden(err), errors.IsNotFound(err):
+		return false
+	case errors.IsServerTimeout(err),
+		errors.IsTimeout(err),
+		errors.IsTooManyRequests(err),
+		errors.IsInternalError(err),
+		errors.IsUnexpectedServerError(err),
+		errors.ReasonForError(err) == metav1.StatusReasonServiceUnavailable:
+		return true
+	}
+
+	if uerr, ok := err.(*url.Error); ok {
+		err = uerr.Err
+	}
+	_, ok := err.(net.Error)
+	return ok
+}
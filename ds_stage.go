@@ -0,0 +1,146 @@
+package kail
+
+import (
+	logutil "github.com/boz/go-logutil"
+	"github.com/boz/kcache/filter"
+	"github.com/boz/kcache/types/node"
+	"github.com/boz/kcache/types/pod"
+	"github.com/boz/kcache/types/replicaset"
+)
+
+// dynamicStage is a filtered pod controller whose filter depends on state
+// that changes over time.  The filter is recomputed each time the state
+// changes; the stage is ready once the first filter has been applied.
+type dynamicStage struct {
+	name    string
+	target  pod.FilterController
+	compute func() (filter.Filter, error)
+	readych chan struct{}
+
+	// refreshch requests that the filter be recomputed without a change.
+	refreshch chan struct{}
+
+	log logutil.Log
+}
+
+// addStage runs a dynamic stage that recomputes the filter of target each
+// time changes fires.  The chain is not ready until the stage is.
+func (c *dsChain) addStage(
+	name string, target pod.FilterController,
+	changes <-chan struct{}, compute func() (filter.Filter, error)) *dynamicStage {
+
+	stage := &dynamicStage{
+		name:    name,
+		target:  target,
+		compute: compute,
+		readych: make(chan struct{}),
+
+		refreshch: make(chan struct{}, 1),
+
+		log: c.log.WithComponent("stage " + name),
+	}
+	c.stages = append(c.stages, stage)
+
+	go stage.run(changes)
+	return stage
+}
+
+// refresh recomputes the filter once the stage is ready.
+func (s *dynamicStage) refresh() {
+	select {
+	case s.refreshch <- struct{}{}:
+	default:
+	}
+}
+
+func (s *dynamicStage) run(changes <-chan struct{}) {
+	ready := false
+	for {
+		select {
+		case _, ok := <-changes:
+			if !ok {
+				return
+			}
+		case <-s.refreshch:
+			if !ready {
+				continue
+			}
+		}
+
+		f, err := s.compute()
+		if err != nil {
+			s.log.ErrWarn(err, "computing filter")
+			continue
+		}
+		if err := s.target.Refilter(f); err != nil {
+			s.log.ErrWarn(err, "applying filter")
+			continue
+		}
+		if !ready {
+			ready = true
+			close(s.readych)
+		}
+	}
+}
+
+// podChanges signals once the subscription is ready and after each
+// subsequent event.  Signals are coalesced; the returned channel is closed
+// when the subscription is done.
+func podChanges(sub pod.Subscription) <-chan struct{} {
+	return changes(sub.Ready(), sub.Done(), func() bool {
+		select {
+		case _, ok := <-sub.Events():
+			return ok
+		case <-sub.Done():
+			return false
+		}
+	})
+}
+
+// nodeChanges is podChanges for node subscriptions.
+func nodeChanges(sub node.Subscription) <-chan struct{} {
+	return changes(sub.Ready(), sub.Done(), func() bool {
+		select {
+		case _, ok := <-sub.Events():
+			return ok
+		case <-sub.Done():
+			return false
+		}
+	})
+}
+
+// rsChanges is podChanges for replica set subscriptions.
+func rsChanges(sub replicaset.Subscription) <-chan struct{} {
+	return changes(sub.Ready(), sub.Done(), func() bool {
+		select {
+		case _, ok := <-sub.Events():
+			return ok
+		case <-sub.Done():
+			return false
+		}
+	})
+}
+
+// changes signals once readych is closed and after each subsequent call to
+// next returns, until it returns false or donech is closed first.  Events
+// received before readych is closed are left to next, where they cause
+// only redundant signals.  Signals are coalesced; the returned channel is
+// closed when done.
+func changes(readych, donech <-chan struct{}, next func() bool) <-chan struct{} {
+	ch := make(chan struct{}, 1)
+	go func() {
+		defer close(ch)
+		select {
+		case <-readych:
+		case <-donech:
+			return
+		}
+		for ok := true; ok; ok = next() {
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return ch
+}
@@ -0,0 +1,1323 @@
+package kail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	logutil "github.com/boz/go-logutil"
+	"github.com/boz/kcache/filter"
+	"github.com/boz/kcache/nsname"
+	"github.com/boz/kcache/types/pod"
+	"k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	ktesting "k8s.io/client-go/testing"
+)
+
+const testTimeout = 5 * time.Second
+
+func testPod(ns, name string, labels map[string]string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       ns,
+			Name:            name,
+			Labels:          labels,
+			ResourceVersion: "1",
+		},
+		Status: v1.PodStatus{Phase: v1.PodRunning},
+	}
+}
+
+// createTestDS creates a datastore from b and waits for it to be ready.
+// Callers must close it with closeTestDS.
+func createTestDS(t *testing.T, b DSBuilder, cs kubernetes.Interface) DS {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	ds, err := b.Create(context.Background(), cs)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	if err := ds.ReadyContext(ctx); err != nil {
+		closeTestDS(t, ds)
+		t.Fatalf("ready: %v", err)
+	}
+	return ds
+}
+
+func closeTestDS(t *testing.T, ds DS) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	if err := ds.CloseAndWait(ctx); err != nil {
+		t.Errorf("close: %v", err)
+	}
+}
+
+// podNames returns the sorted ids of pods.
+func podNames(pods []*v1.Pod) []string {
+	names := make([]string, 0, len(pods))
+	for _, pod := range pods {
+		names = append(names, nsname.New(pod.Namespace, pod.Name).String())
+	}
+	sort.Strings(names)
+	return names
+}
+
+// matched returns the sorted ids of the pods ds currently matches.
+func matched(t *testing.T, ds DS) []string {
+	t.Helper()
+
+	pods, err := ds.Pods().Cache().List()
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	return podNames(pods)
+}
+
+// waitMatched waits for the pods ds matches to be exactly want.
+func waitMatched(t *testing.T, ds DS, want ...string) {
+	t.Helper()
+
+	sort.Strings(want)
+	deadline := time.Now().Add(testTimeout)
+	for {
+		got := matched(t, ds)
+		if equalStrings(got, want) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("matched: got %v, want %v", got, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// fakeController is a pod controller whose readiness and completion are
+// driven by the test.  It holds no pods.
+type fakeController struct {
+	readych   chan struct{}
+	donech    chan struct{}
+	closes    int32
+	hang      bool
+	readyOnce sync.Once
+	doneOnce  sync.Once
+}
+
+func newFakeController() *fakeController {
+	return &fakeController{
+		readych: make(chan struct{}),
+		donech:  make(chan struct{}),
+	}
+}
+
+func (c *fakeController) ready()  { c.readyOnce.Do(func() { close(c.readych) }) }
+func (c *fakeController) finish() { c.doneOnce.Do(func() { close(c.donech) }) }
+
+// Close records the call and completes the controller, unless it hangs.
+func (c *fakeController) Close() {
+	atomic.AddInt32(&c.closes, 1)
+	if !c.hang {
+		c.finish()
+	}
+}
+
+func (c *fakeController) closeCount() int {
+	return int(atomic.LoadInt32(&c.closes))
+}
+
+func (c *fakeController) Ready() <-chan struct{} { return c.readych }
+func (c *fakeController) Done() <-chan struct{}  { return c.donech }
+func (c *fakeController) Cache() pod.CacheReader { return fakeCache{} }
+func (c *fakeController) Subscribe() (pod.Subscription, error) {
+	return nil, errors.New("fake controller: subscribe unsupported")
+}
+func (c *fakeController) Clone() (pod.Controller, error) {
+	return nil, errors.New("fake controller: clone unsupported")
+}
+func (c *fakeController) CloneWithFilter(filter.Filter) (pod.FilterController, error) {
+	return nil, errors.New("fake controller: clone unsupported")
+}
+func (c *fakeController) CloneForFilter() (pod.FilterController, error) {
+	return nil, errors.New("fake controller: clone unsupported")
+}
+
+type fakeCache []*v1.Pod
+
+func (c fakeCache) List() ([]*v1.Pod, error) { return c, nil }
+func (c fakeCache) Get(ns, name string) (*v1.Pod, error) {
+	for _, pod := range c {
+		if pod.Namespace == ns && pod.Name == name {
+			return pod, nil
+		}
+	}
+	return nil, nil
+}
+
+// newTestDatastore returns a running datastore whose base pod controller
+// is base and whose matched pods are those of pods, bypassing Create.  The
+// two may be the same controller.
+func newTestDatastore(ctx context.Context, base, pods *fakeController) *datastore {
+	ds := testDatastore(ctx, base, pods)
+	ds.run(ctx)
+	return ds
+}
+
+// testDatastore is newTestDatastore without running the datastore, so that
+// the test may configure it first.
+func testDatastore(ctx context.Context, base, pods *fakeController) *datastore {
+	chain := newDSChain(logutil.FromContextOrDefault(ctx))
+	chain.podBase, chain.pods = base, pods
+
+	return &datastore{
+		current:      chain,
+		feeds:        make(map[*podFeed]bool),
+		quietMissing: true,
+		clock:        clock.RealClock{},
+		metrics:      nullMetrics{},
+		streams:      newStreamLimiter(0),
+		readych:      make(chan struct{}),
+		donech:       make(chan struct{}),
+		closech:      make(chan struct{}),
+		log:          logutil.FromContextOrDefault(ctx),
+	}
+}
+
+func TestDSReadiness(t *testing.T) {
+	tests := []struct {
+		name  string
+		drive func(c *fakeController)
+		ready bool
+	}{
+		{"ready", (*fakeController).ready, true},
+		{"done before ready", (*fakeController).finish, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+			defer cancel()
+
+			c := newFakeController()
+			ds := newTestDatastore(context.Background(), c, c)
+			defer ds.Close()
+
+			test.drive(c)
+
+			err := ds.ReadyContext(ctx)
+			if test.ready {
+				if err != nil {
+					t.Fatalf("ready: %v", err)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("ready: got nil error for controller done before ready")
+			}
+			select {
+			case <-ds.Done():
+			case <-ctx.Done():
+				t.Fatalf("datastore not done")
+			}
+			select {
+			case <-ds.Ready():
+				t.Errorf("ready closed for controller done before ready")
+			default:
+			}
+			if ds.ReadyErr() == nil {
+				t.Errorf("ReadyErr: got nil")
+			}
+		})
+	}
+}
+
+func TestDSCloseIdempotent(t *testing.T) {
+	tests := []struct {
+		name  string
+		close func(ds DS)
+	}{
+		{"twice", func(ds DS) {
+			ds.Close()
+			ds.Close()
+		}},
+		{"concurrently", func(ds DS) {
+			var wg sync.WaitGroup
+			for i := 0; i < 2; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					ds.Close()
+				}()
+			}
+			wg.Wait()
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			base, pods := newFakeController(), newFakeController()
+			base.ready()
+			pods.ready()
+			ds := newTestDatastore(context.Background(), base, pods)
+
+			test.close(ds)
+
+			select {
+			case <-ds.Done():
+			case <-time.After(testTimeout):
+				t.Fatalf("datastore not done")
+			}
+			for _, c := range []*fakeController{base, pods} {
+				if n := c.closeCount(); n != 1 {
+					t.Errorf("close count: got %v, want 1", n)
+				}
+			}
+		})
+	}
+}
+
+func TestDSContextCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	c := newFakeController()
+	c.ready()
+	ds := newTestDatastore(ctx, c, c)
+
+	cancel()
+
+	select {
+	case <-ds.Done():
+	case <-time.After(testTimeout):
+		t.Fatalf("datastore not done after context cancelled")
+	}
+	if !ds.Closed() {
+		t.Errorf("closed: got false")
+	}
+}
+
+func TestDSShutdownGrace(t *testing.T) {
+	const grace = 10 * time.Second
+
+	tests := []struct {
+		name  string
+		grace time.Duration
+		done  bool
+	}{
+		{"grace", grace, true},
+		{"no grace", 0, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clk := clock.NewFakeClock(time.Now())
+
+			base, pods := newFakeController(), newFakeController()
+			base.hang = true
+			base.ready()
+			pods.ready()
+
+			ds := testDatastore(context.Background(), base, pods)
+			ds.clock = clk
+			ds.grace = test.grace
+			ds.run(context.Background())
+			defer base.finish()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			if err := ds.CloseAndWait(ctx); err != context.DeadlineExceeded {
+				t.Fatalf("close and wait: got %v, want %v", err, context.DeadlineExceeded)
+			}
+
+			if test.grace > 0 {
+				for !clk.HasWaiters() {
+					time.Sleep(time.Millisecond)
+				}
+				clk.Step(test.grace - time.Millisecond)
+				select {
+				case <-ds.Done():
+					t.Fatalf("done before grace period")
+				case <-time.After(50 * time.Millisecond):
+				}
+				clk.Step(time.Millisecond)
+			}
+
+			select {
+			case <-ds.Done():
+				if !test.done {
+					t.Fatalf("done with a controller hung")
+				}
+			case <-time.After(100 * time.Millisecond):
+				if test.done {
+					t.Fatalf("not done after grace period")
+				}
+			}
+		})
+	}
+}
+
+// failList makes cs fail to list the given resource.
+func failList(cs *fake.Clientset, resource string, err error) {
+	cs.PrependReactor("list", resource, func(ktesting.Action) (bool, runtime.Object, error) {
+		return true, nil, err
+	})
+}
+
+func TestDSCreateFailure(t *testing.T) {
+	tests := []struct {
+		name     string
+		resource string
+		builder  DSBuilder
+	}{
+		{"deployment", "deployments",
+			NewDSBuilder().WithAllNamespaces().WithDeployment(nsname.New("default", "web"))},
+		{"service", "services",
+			NewDSBuilder().WithAllNamespaces().WithService(nsname.New("default", "web"))},
+		{"deployment after service", "deployments",
+			NewDSBuilder().WithAllNamespaces().
+				WithService(nsname.New("default", "web")).
+				WithDeployment(nsname.New("default", "web"))},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cs := fake.NewSimpleClientset(testPod("default", "web", nil))
+			failList(cs, test.resource, errors.New("injected failure"))
+
+			ds, err := test.builder.Create(context.Background(), cs)
+			if err == nil {
+				closeTestDS(t, ds)
+				t.Fatalf("create: got nil error")
+			}
+			if ds != nil {
+				t.Errorf("create: got datastore with error %v", err)
+			}
+		})
+	}
+}
+
+func TestDSIgnoreForbidden(t *testing.T) {
+	forbidden := apierrors.NewForbidden(
+		schema.GroupResource{Group: "apps", Resource: "deployments"}, "", errors.New("injected failure"))
+	web := nsname.New("default", "web")
+
+	tests := []struct {
+		name    string
+		builder DSBuilder
+		err     error
+		want    []string
+	}{
+		{"fail", NewDSBuilder().WithDeployment(web), forbidden, nil},
+		{"ignore forbidden", NewDSBuilder().WithIgnoreForbidden().WithDeployment(web), forbidden,
+			[]string{"default/api", "default/web"}},
+		{"other criteria kept", NewDSBuilder().WithIgnoreForbidden().WithService(web).WithDeployment(web), forbidden,
+			[]string{"default/web"}},
+		{"forbidden only", NewDSBuilder().WithIgnoreForbidden().WithDeployment(web), errors.New("injected failure"), nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cs := fake.NewSimpleClientset(
+				testService("default", "web", map[string]string{"app": "web"}),
+				testPod("default", "web", map[string]string{"app": "web"}),
+				testPod("default", "api", map[string]string{"app": "api"}),
+			)
+			failList(cs, "deployments", test.err)
+
+			b := test.builder.WithAllNamespaces()
+
+			if test.want == nil {
+				ds, err := b.Create(context.Background(), cs)
+				if err == nil {
+					closeTestDS(t, ds)
+					t.Fatalf("create: got nil error")
+				}
+				return
+			}
+
+			ds := createTestDS(t, b, cs)
+			defer closeTestDS(t, ds)
+
+			waitMatched(t, ds, test.want...)
+		})
+	}
+}
+
+func TestDSCreatePartialFailure(t *testing.T) {
+	tests := []struct {
+		name     string
+		resource string
+	}{
+		{"first", "services"},
+		{"middle", "replicasets"},
+		{"last", "deployments"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var watchers []*watch.FakeWatcher
+
+			cs := fake.NewSimpleClientset(testPod("default", "web", nil))
+			cs.PrependWatchReactor("*", func(ktesting.Action) (bool, watch.Interface, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				w := watch.NewFake()
+				watchers = append(watchers, w)
+				return true, w, nil
+			})
+			failList(cs, test.resource, errors.New("injected failure"))
+
+			web := nsname.New("default", "web")
+			ds, err := NewDSBuilder().WithAllNamespaces().
+				WithService(web).
+				WithRS(web).
+				WithDeployment(web).
+				Create(context.Background(), cs)
+			if err == nil {
+				closeTestDS(t, ds)
+				t.Fatalf("create: got nil error")
+			}
+
+			// every controller created before the failure has been closed,
+			// stopping its watch.
+			mu.Lock()
+			defer mu.Unlock()
+			if len(watchers) == 0 {
+				t.Fatalf("no watches started")
+			}
+			for i, w := range watchers {
+				if !w.IsStopped() {
+					t.Errorf("watch %v not stopped", i)
+				}
+			}
+		})
+	}
+}
+
+func TestDSControllersDeduplicated(t *testing.T) {
+	shared := newFakeController()
+	other := newFakeController()
+
+	tests := []struct {
+		name        string
+		base, pods  *fakeController
+		controllers int
+	}{
+		{"shared", shared, shared, 1},
+		{"distinct", other, newFakeController(), 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.base.ready()
+			test.pods.ready()
+			ds := newTestDatastore(context.Background(), test.base, test.pods)
+
+			if n := len(ds.controllers()); n != test.controllers {
+				t.Errorf("controllers: got %v, want %v", n, test.controllers)
+			}
+
+			closeTestDS(t, ds)
+
+			for _, c := range []*fakeController{test.base, test.pods} {
+				if n := c.closeCount(); n != 1 {
+					t.Errorf("close count: got %v, want 1", n)
+				}
+			}
+		})
+	}
+}
+
+func TestDSJoin(t *testing.T) {
+	cs := fake.NewSimpleClientset(
+		testPod("default", "web", map[string]string{"app": "web"}),
+		testPod("default", "api", map[string]string{"app": "api"}),
+	)
+
+	var joined pod.Controller
+	var joinCtx context.Context
+	web := func(ctx context.Context, pods pod.Controller) (pod.Controller, error) {
+		joinCtx = ctx
+		clone, err := pods.CloneWithFilter(filter.Selector(labels.SelectorFromSet(map[string]string{"app": "web"})))
+		joined = clone
+		return clone, err
+	}
+
+	ds := createTestDS(t, NewDSBuilder().WithNamespace("default").WithJoin(web), cs)
+	waitMatched(t, ds, "default/web")
+	closeTestDS(t, ds)
+
+	select {
+	case <-joined.Done():
+	case <-time.After(testTimeout):
+		t.Errorf("joined controller not closed with the datastore")
+	}
+	if joinCtx.Err() == nil {
+		t.Errorf("join context not cancelled with the datastore")
+	}
+}
+
+func TestDSJoinFailure(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func(context.Context, pod.Controller) (pod.Controller, error)
+		want string
+	}{
+		{"error", func(context.Context, pod.Controller) (pod.Controller, error) {
+			return nil, errors.New("injected failure")
+		}, "join 1: injected failure"},
+		{"nil controller", func(context.Context, pod.Controller) (pod.Controller, error) {
+			return nil, nil
+		}, "join 1: no pod controller returned"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cs := fake.NewSimpleClientset(testPod("default", "web", nil))
+
+			keep := func(_ context.Context, pods pod.Controller) (pod.Controller, error) {
+				return pods, nil
+			}
+
+			ds, err := NewDSBuilder().WithNamespace("default").
+				WithJoin(keep).
+				WithJoin(test.fn).
+				Create(context.Background(), cs)
+			if err == nil {
+				closeTestDS(t, ds)
+				t.Fatalf("create: got nil error")
+			}
+			if !strings.Contains(err.Error(), test.want) {
+				t.Errorf("create: got error %q, want %q", err, test.want)
+			}
+		})
+	}
+}
+
+// trackedPods wraps a pod controller, counting the controllers cloned from
+// it, directly or through its clones, that have not been closed.
+type trackedPods struct {
+	pod.Controller
+	open      *int64
+	closeOnce sync.Once
+}
+
+func (c *trackedPods) Close() {
+	c.closeOnce.Do(func() { atomic.AddInt64(c.open, -1) })
+	c.Controller.Close()
+}
+
+func (c *trackedPods) Clone() (pod.Controller, error) {
+	cc, err := c.Controller.Clone()
+	if err != nil {
+		return nil, err
+	}
+	return c.track(cc), nil
+}
+
+func (c *trackedPods) CloneWithFilter(f filter.Filter) (pod.FilterController, error) {
+	cc, err := c.Controller.CloneWithFilter(f)
+	if err != nil {
+		return nil, err
+	}
+	return &trackedFilterPods{c.track(cc), cc}, nil
+}
+
+func (c *trackedPods) CloneForFilter() (pod.FilterController, error) {
+	cc, err := c.Controller.CloneForFilter()
+	if err != nil {
+		return nil, err
+	}
+	return &trackedFilterPods{c.track(cc), cc}, nil
+}
+
+func (c *trackedPods) track(cc pod.Controller) *trackedPods {
+	atomic.AddInt64(c.open, 1)
+	return &trackedPods{Controller: cc, open: c.open}
+}
+
+type trackedFilterPods struct {
+	*trackedPods
+	fc pod.FilterController
+}
+
+func (c *trackedFilterPods) Refilter(f filter.Filter) error {
+	return c.fc.Refilter(f)
+}
+
+func TestDSSharedBaseClosed(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cs := fake.NewSimpleClientset(
+		testNamespace("default", map[string]string{"env": "prod"}),
+		testService("default", "web", map[string]string{"app": "web"}),
+		testPod("default", "web", map[string]string{"app": "web"}),
+		testPod("default", "api", map[string]string{"app": "api"}),
+	)
+
+	base, err := pod.NewController(ctx, logutil.FromContextOrDefault(ctx), cs, "")
+	if err != nil {
+		t.Fatalf("base pod controller: %v", err)
+	}
+	defer base.Close()
+
+	var open int64
+	tracked := &trackedPods{Controller: base, open: &open}
+
+	b := NewSharedDSBuilder(tracked).
+		WithNamespaceSelector(labels.SelectorFromSet(map[string]string{"env": "prod"})).
+		WithService(nsname.New("default", "web")).
+		WithLimit(1)
+
+	ds := createTestDS(t, b, cs)
+	waitMatched(t, ds, "default/web")
+
+	if err := ds.Refresh(ctx); err != nil {
+		closeTestDS(t, ds)
+		t.Fatalf("refresh: %v", err)
+	}
+	waitMatched(t, ds, "default/web")
+
+	closeTestDS(t, ds)
+
+	if n := atomic.LoadInt64(&open); n != 0 {
+		t.Errorf("clones of the shared base: %v left open", n)
+	}
+	select {
+	case <-base.Done():
+		t.Errorf("shared base closed with the datastore")
+	default:
+	}
+}
+
+func testNamespace(name string, labels map[string]string) *v1.Namespace {
+	return &v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+		Status:     v1.NamespaceStatus{Phase: v1.NamespaceActive},
+	}
+}
+
+func testService(ns, name string, selector map[string]string) *v1.Service {
+	return &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: name},
+		Spec:       v1.ServiceSpec{Selector: selector},
+	}
+}
+
+func TestDSAddNamespace(t *testing.T) {
+	prod := map[string]string{"env": "prod"}
+
+	tests := []struct {
+		name    string
+		builder DSBuilder
+		add     string
+		before  []string
+		after   []string
+	}{
+		{"namespace", NewDSBuilder().WithNamespace("prod", "staging"), "dev",
+			[]string{"prod/api", "prod/web"},
+			[]string{"dev/web", "prod/api", "prod/web"}},
+		{"namespace selector", NewDSBuilder().WithNamespaceSelector(labels.SelectorFromSet(prod)), "dev",
+			[]string{"prod/api", "prod/web"},
+			[]string{"dev/web", "prod/api", "prod/web"}},
+		{"namespace and selector",
+			NewDSBuilder().WithNamespace("prod", "dev").WithNamespaceSelector(labels.SelectorFromSet(prod)), "dev",
+			[]string{"prod/api", "prod/web"},
+			[]string{"dev/web", "prod/api", "prod/web"}},
+		{"other criteria", NewDSBuilder().WithNamespace("prod", "staging").WithLabel("app", "web"), "dev",
+			[]string{"prod/web"},
+			[]string{"dev/web", "prod/web"}},
+		{"already watched", NewDSBuilder().WithNamespace("prod", "staging"), "prod",
+			[]string{"prod/api", "prod/web"},
+			[]string{"prod/api", "prod/web"}},
+		{"single namespace", NewDSBuilder().WithNamespace("prod"), "dev",
+			[]string{"prod/api", "prod/web"},
+			[]string{"dev/web", "prod/api", "prod/web"}},
+		{"single namespace with other criteria", NewDSBuilder().WithNamespace("prod").WithLabel("app", "web"), "dev",
+			[]string{"prod/web"},
+			[]string{"dev/web", "prod/web"}},
+		{"single namespace already watched", NewDSBuilder().WithNamespace("prod"), "prod",
+			[]string{"prod/api", "prod/web"},
+			[]string{"prod/api", "prod/web"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cs := fake.NewSimpleClientset(
+				testNamespace("prod", prod),
+				testNamespace("dev", nil),
+				testNamespace("test", nil),
+				testPod("prod", "web", map[string]string{"app": "web"}),
+				testPod("prod", "api", map[string]string{"app": "api"}),
+				testPod("dev", "web", map[string]string{"app": "web"}),
+				testPod("test", "web", map[string]string{"app": "web"}),
+			)
+
+			ds := createTestDS(t, test.builder, cs)
+			defer closeTestDS(t, ds)
+
+			waitMatched(t, ds, test.before...)
+
+			if err := ds.AddNamespace(test.add); err != nil {
+				t.Fatalf("add namespace: %v", err)
+			}
+			waitMatched(t, ds, test.after...)
+		})
+	}
+}
+
+func TestDSPodWatch(t *testing.T) {
+	many := make([]nsname.NSName, 0, podWatchLimit+1)
+	for i := 0; i <= podWatchLimit; i++ {
+		many = append(many, nsname.New("prod", fmt.Sprintf("pod-%02d", i)))
+	}
+
+	tests := []struct {
+		name    string
+		builder DSBuilder
+		listed  []string
+		matched []string
+	}{
+		{"named pods", NewDSBuilder().WithPods(nsname.New("prod", "web"), nsname.New("dev", "web")),
+			[]string{"dev metadata.name=web", "prod metadata.name=web"},
+			[]string{"dev/web", "prod/web"}},
+		{"duplicate pods", NewDSBuilder().WithPods(nsname.New("prod", "web"), nsname.New("prod", "web")),
+			[]string{"prod metadata.name=web"},
+			[]string{"prod/web"}},
+		{"too many pods", NewDSBuilder().WithPods(append(many, nsname.New("prod", "web"))...),
+			[]string{" "},
+			[]string{"prod/web"}},
+		{"pods without namespaces", NewDSBuilder().WithAllNamespaces().WithPods(nsname.New("", "web")),
+			[]string{" "},
+			[]string{"dev/web", "prod/web"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var listed []string
+
+			cs := fake.NewSimpleClientset(
+				testPod("prod", "web", nil),
+				testPod("prod", "api", nil),
+				testPod("dev", "web", nil),
+			)
+			cs.PrependReactor("list", "pods", func(action ktesting.Action) (bool, runtime.Object, error) {
+				var fields string
+				if sel := action.(ktesting.ListAction).GetListRestrictions().Fields; sel != nil {
+					fields = sel.String()
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				listed = append(listed, action.GetNamespace()+" "+fields)
+				return false, nil, nil
+			})
+
+			ds := createTestDS(t, test.builder, cs)
+			defer closeTestDS(t, ds)
+
+			waitMatched(t, ds, test.matched...)
+
+			mu.Lock()
+			defer mu.Unlock()
+			sort.Strings(listed)
+			if !equalStrings(listed, test.listed) {
+				t.Errorf("listed pods with %q, want %q", listed, test.listed)
+			}
+		})
+	}
+}
+
+func TestDSNamespaceSelectorRemoval(t *testing.T) {
+	prod := map[string]string{"env": "prod"}
+
+	tests := []struct {
+		name   string
+		remove func(cs *fake.Clientset) error
+	}{
+		{"deleted", func(cs *fake.Clientset) error {
+			return cs.CoreV1().Namespaces().Delete("staging", &metav1.DeleteOptions{})
+		}},
+		{"terminating", func(cs *fake.Clientset) error {
+			ns := testNamespace("staging", prod)
+			ns.Status.Phase = v1.NamespaceTerminating
+			_, err := cs.CoreV1().Namespaces().Update(ns)
+			return err
+		}},
+		{"relabelled", func(cs *fake.Clientset) error {
+			_, err := cs.CoreV1().Namespaces().Update(testNamespace("staging", nil))
+			return err
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cs := fake.NewSimpleClientset(
+				testNamespace("prod", prod),
+				testNamespace("staging", prod),
+				testPod("prod", "web", nil),
+				testPod("staging", "web", nil),
+				testPod("staging", "api", nil),
+			)
+
+			b := NewDSBuilder().WithNamespaceSelector(labels.SelectorFromSet(prod))
+			ds := createTestDS(t, b, cs)
+			defer closeTestDS(t, ds)
+
+			waitMatched(t, ds, "prod/web", "staging/api", "staging/web")
+
+			if err := test.remove(cs); err != nil {
+				t.Fatalf("remove namespace: %v", err)
+			}
+			waitMatched(t, ds, "prod/web")
+		})
+	}
+}
+
+func TestDSNamespaceListFailure(t *testing.T) {
+	forbidden := apierrors.NewForbidden(
+		schema.GroupResource{Resource: "namespaces"}, "", errors.New("injected failure"))
+
+	// an ignored selector must not widen the matched set: no namespace is
+	// matched but those added explicitly.
+	tests := []struct {
+		name    string
+		builder DSBuilder
+		err     error
+		fail    bool
+	}{
+		{"fail", NewDSBuilder(), forbidden, true},
+		{"ignore forbidden", NewDSBuilder().WithIgnoreForbidden(), forbidden, false},
+		{"forbidden only", NewDSBuilder().WithIgnoreForbidden(), errors.New("injected failure"), true},
+		{"best effort", NewDSBuilder().WithBestEffort(), errors.New("injected failure"), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cs := fake.NewSimpleClientset(
+				testNamespace("prod", map[string]string{"env": "prod"}),
+				testNamespace("staging", nil),
+				testPod("prod", "web", nil),
+				testPod("staging", "web", nil),
+			)
+			failList(cs, "namespaces", test.err)
+
+			b := test.builder.WithNamespaceSelector(labels.SelectorFromSet(map[string]string{"env": "prod"}))
+
+			if test.fail {
+				ds, err := b.Create(context.Background(), cs)
+				if err == nil {
+					closeTestDS(t, ds)
+					t.Fatalf("create: got nil error")
+				}
+				return
+			}
+
+			ds := createTestDS(t, b, cs)
+			defer closeTestDS(t, ds)
+
+			waitMatched(t, ds)
+
+			if err := ds.AddNamespace("staging"); err != nil {
+				t.Fatalf("add namespace: %v", err)
+			}
+			waitMatched(t, ds, "staging/web")
+		})
+	}
+}
+
+func TestDSPDB(t *testing.T) {
+	var mu sync.Mutex
+	var listed []string
+
+	cs := fake.NewSimpleClientset(
+		&policyv1beta1.PodDisruptionBudget{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "prod", Name: "web"},
+			Spec: policyv1beta1.PodDisruptionBudgetSpec{
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+			},
+		},
+		testPod("prod", "web", map[string]string{"app": "web"}),
+		testPod("prod", "db", map[string]string{"app": "db"}),
+		testPod("staging", "web", map[string]string{"app": "web"}),
+	)
+	cs.PrependReactor("list", "poddisruptionbudgets", func(action ktesting.Action) (bool, runtime.Object, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		listed = append(listed, action.GetNamespace())
+		return false, nil, nil
+	})
+
+	b := NewDSBuilder().WithAllNamespaces().WithPDB(nsname.New("prod", "web"))
+	ds := createTestDS(t, b, cs)
+	defer closeTestDS(t, ds)
+
+	waitMatched(t, ds, "prod/web")
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !equalStrings(listed, []string{"prod"}) {
+		t.Errorf("listed pod disruption budgets in %q, want only the budget's namespace", listed)
+	}
+}
+
+func TestDSPDBListFailure(t *testing.T) {
+	forbidden := apierrors.NewForbidden(
+		schema.GroupResource{Group: "policy", Resource: "poddisruptionbudgets"}, "", errors.New("injected failure"))
+
+	// ignored budgets must not widen the matched set: no pod is matched.
+	tests := []struct {
+		name    string
+		builder DSBuilder
+		err     error
+		fail    bool
+	}{
+		{"fail", NewDSBuilder(), forbidden, true},
+		{"ignore forbidden", NewDSBuilder().WithIgnoreForbidden(), forbidden, false},
+		{"forbidden only", NewDSBuilder().WithIgnoreForbidden(), errors.New("injected failure"), true},
+		{"best effort", NewDSBuilder().WithBestEffort(), errors.New("injected failure"), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cs := fake.NewSimpleClientset(
+				testPod("prod", "web", map[string]string{"app": "web"}),
+				testPod("prod", "db", map[string]string{"app": "db"}),
+			)
+			failList(cs, "poddisruptionbudgets", test.err)
+
+			b := test.builder.WithNamespace("prod").WithPDB(nsname.New("prod", "web"))
+
+			if test.fail {
+				ds, err := b.Create(context.Background(), cs)
+				if err == nil {
+					closeTestDS(t, ds)
+					t.Fatalf("create: got nil error")
+				}
+				return
+			}
+
+			ds := createTestDS(t, b, cs)
+			defer closeTestDS(t, ds)
+
+			waitMatched(t, ds)
+		})
+	}
+}
+
+func TestDSRefreshRelists(t *testing.T) {
+	var mu sync.Mutex
+	served := []*v1.Pod{
+		testPod("default", "changed", nil),
+		testPod("default", "kept", nil),
+		testPod("default", "stale", nil),
+	}
+
+	cs := fake.NewSimpleClientset()
+	cs.PrependReactor("list", "pods", func(ktesting.Action) (bool, runtime.Object, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		list := &v1.PodList{}
+		for _, pod := range served {
+			list.Items = append(list.Items, *pod)
+		}
+		return true, list, nil
+	})
+	// watches deliver nothing, so the caches go stale until relisted.
+	cs.PrependWatchReactor("pods", func(ktesting.Action) (bool, watch.Interface, error) {
+		return true, watch.NewFake(), nil
+	})
+
+	ds := createTestDS(t, NewDSBuilder().WithNamespace("default"), cs)
+	defer closeTestDS(t, ds)
+
+	waitMatched(t, ds, "default/changed", "default/kept", "default/stale")
+
+	sub := ds.Subscribe()
+	defer sub.Close()
+
+	next := func() DSEvent {
+		t.Helper()
+		select {
+		case ev, ok := <-sub.Events():
+			if !ok {
+				t.Fatalf("subscription closed")
+			}
+			return ev
+		case <-time.After(testTimeout):
+			t.Fatalf("timed out waiting for event")
+		}
+		return DSEvent{}
+	}
+	for next().Type != DSEventInitialListComplete {
+	}
+
+	changed := testPod("default", "changed", nil)
+	changed.ResourceVersion = "2"
+
+	mu.Lock()
+	served = []*v1.Pod{changed, testPod("default", "kept", nil), testPod("default", "fresh", nil)}
+	mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	if err := ds.Refresh(ctx); err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+
+	waitMatched(t, ds, "default/changed", "default/fresh", "default/kept")
+
+	var got []string
+	for i := 0; i < 3; i++ {
+		ev := next()
+		got = append(got, ev.Type.String()+" "+nsname.New(ev.Pod.Namespace, ev.Pod.Name).String())
+	}
+	sort.Strings(got)
+	want := []string{"added default/fresh", "removed default/stale", "updated default/changed"}
+	if !equalStrings(got, want) {
+		t.Errorf("events: got %v, want %v", got, want)
+	}
+
+	select {
+	case ev := <-sub.Events():
+		t.Errorf("unexpected event: %v %v/%v", ev.Type, ev.Pod.Namespace, ev.Pod.Name)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestDSSnapshot(t *testing.T) {
+	tests := []struct {
+		name string
+		pods []*v1.Pod
+		want []string
+	}{
+		{"empty", nil, nil},
+		{"by name", []*v1.Pod{
+			testPod("default", "web", nil),
+			testPod("default", "api", nil),
+			testPod("default", "worker", nil),
+		}, []string{"default/api", "default/web", "default/worker"}},
+		{"by namespace", []*v1.Pod{
+			testPod("prod", "web", nil),
+			testPod("default", "web", nil),
+			testPod("kube-system", "dns", nil),
+			testPod("default", "api", nil),
+		}, []string{"default/api", "default/web", "kube-system/dns", "prod/web"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var objs []runtime.Object
+			for _, pod := range test.pods {
+				objs = append(objs, pod)
+			}
+			cs := fake.NewSimpleClientset(objs...)
+
+			ds := createTestDS(t, NewDSBuilder().WithAllNamespaces(), cs)
+			defer closeTestDS(t, ds)
+
+			waitMatched(t, ds, test.want...)
+
+			first := ds.Snapshot()
+			for i := 0; i < 10; i++ {
+				snapshot := ds.Snapshot()
+				if len(snapshot) != len(first) {
+					t.Fatalf("snapshot %v: got %v pods, want %v", i, len(snapshot), len(first))
+				}
+				for j := range snapshot {
+					if snapshot[j] != first[j] {
+						t.Fatalf("snapshot %v: order changed at %v", i, j)
+					}
+				}
+			}
+
+			var got []string
+			for _, pod := range first {
+				got = append(got, nsname.New(pod.Namespace, pod.Name).String())
+			}
+			if !equalStrings(got, test.want) {
+				t.Errorf("snapshot: got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDSChannelsClosedOnClose(t *testing.T) {
+	tests := []struct {
+		name    string
+		builder DSBuilder
+		drain   func(ds DS) (func(), error)
+	}{
+		{"events", NewDSBuilder(), func(ds DS) (func(), error) {
+			ch := ds.Events()
+			return func() {
+				for range ch {
+				}
+			}, nil
+		}},
+		{"subscription", NewDSBuilder(), func(ds DS) (func(), error) {
+			ch := ds.Subscribe().Events()
+			return func() {
+				for range ch {
+				}
+			}, nil
+		}},
+		{"kube events", NewDSBuilder().WithEvents(true), func(ds DS) (func(), error) {
+			ch := ds.KubeEvents()
+			return func() {
+				for range ch {
+				}
+			}, nil
+		}},
+		{"stream", NewDSBuilder(), func(ds DS) (func(), error) {
+			ch, err := ds.Stream(context.Background())
+			return func() {
+				for range ch {
+				}
+			}, err
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cs := fake.NewSimpleClientset(testPod("default", "web", nil))
+			ds := createTestDS(t, test.builder.WithNamespace("default"), cs)
+
+			drain, err := test.drain(ds)
+			if err != nil {
+				closeTestDS(t, ds)
+				t.Fatalf("subscribe: %v", err)
+			}
+
+			donech := make(chan struct{})
+			go func() {
+				defer close(donech)
+				drain()
+			}()
+
+			closeTestDS(t, ds)
+
+			select {
+			case <-donech:
+			case <-time.After(testTimeout):
+				t.Fatalf("range did not exit after close")
+			}
+		})
+	}
+}
+
+func TestDSReconfigure(t *testing.T) {
+	web := nsname.New("default", "web")
+	worker := nsname.New("default", "worker")
+
+	tests := []struct {
+		name   string
+		before DSBuilder
+		after  DSBuilder
+		events []string
+	}{
+		{"labels",
+			NewDSBuilder().WithNamespace("default").WithLabel("app", "web"),
+			NewDSBuilder().WithNamespace("default").WithLabel("app", "worker"),
+			[]string{"added default/worker", "removed default/web"}},
+		{"widen",
+			NewDSBuilder().WithNamespace("default").WithLabel("app", "web"),
+			NewDSBuilder().WithNamespace("default"),
+			[]string{"added default/worker"}},
+		{"service",
+			NewDSBuilder().WithNamespace("default").WithService(web),
+			NewDSBuilder().WithNamespace("default").WithService(worker),
+			[]string{"added default/worker", "removed default/web"}},
+		{"unchanged",
+			NewDSBuilder().WithNamespace("default").WithService(web),
+			NewDSBuilder().WithNamespace("default").WithLabel("app", "web"),
+			nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cs := fake.NewSimpleClientset(
+				testPod("default", "web", map[string]string{"app": "web"}),
+				testPod("default", "worker", map[string]string{"app": "worker"}),
+				testService("default", "web", map[string]string{"app": "web"}),
+				testService("default", "worker", map[string]string{"app": "worker"}),
+			)
+
+			ds := createTestDS(t, test.before, cs)
+			defer closeTestDS(t, ds)
+
+			sub := ds.Subscribe()
+			defer sub.Close()
+
+			next := func(timeout time.Duration) (DSEvent, bool) {
+				select {
+				case ev, ok := <-sub.Events():
+					return ev, ok
+				case <-time.After(timeout):
+					return DSEvent{}, false
+				}
+			}
+			for {
+				ev, ok := next(testTimeout)
+				if !ok {
+					t.Fatalf("no initial list complete event")
+				}
+				if ev.Type == DSEventInitialListComplete {
+					break
+				}
+			}
+
+			if err := ds.Reconfigure(test.after); err != nil {
+				t.Fatalf("reconfigure: %v", err)
+			}
+
+			// the swap is complete, so every event has been queued.
+			var got []string
+			for {
+				ev, ok := next(100 * time.Millisecond)
+				if !ok {
+					break
+				}
+				got = append(got, ev.Type.String()+" "+nsname.New(ev.Pod.Namespace, ev.Pod.Name).String())
+			}
+			sort.Strings(got)
+			if !equalStrings(got, test.events) {
+				t.Errorf("events: got %v, want %v", got, test.events)
+			}
+		})
+	}
+}
@@ -7,25 +7,60 @@ import (
 	"k8s.io/api/core/v1"
 )
 
+// ContainerFilter selects the containers whose logs are streamed.  Accept
+// is given regular containers (status.containerStatuses) and AcceptInit
+// init containers (status.initContainerStatuses).
 type ContainerFilter interface {
 	Accept(cs v1.ContainerStatus) bool
+	AcceptInit(cs v1.ContainerStatus) bool
 }
 
-func NewContainerFilter(names []string) ContainerFilter {
-	return containerFilter(names)
+// NewContainerFilter returns a filter accepting ready containers named in
+// include (all containers if include is empty) and not named in exclude.
+// Init containers are not accepted.
+func NewContainerFilter(include []string, exclude []string) ContainerFilter {
+	return containerFilter{include, exclude, false}
 }
 
-type containerFilter []string
+type containerFilter struct {
+	include []string
+	exclude []string
+	init    bool
+}
 
 func (cf containerFilter) Accept(cs v1.ContainerStatus) bool {
 	if !cs.Ready {
 		return false
 	}
-	if len(cf) == 0 {
+	return cf.acceptName(cs.Name)
+}
+
+// AcceptInit accepts init containers that have started, if enabled.
+// Unlike regular containers, init containers are accepted after they have
+// terminated so that their logs can be read to completion.
+func (cf containerFilter) AcceptInit(cs v1.ContainerStatus) bool {
+	if !cf.init {
+		return false
+	}
+	if cs.State.Running == nil && cs.State.Terminated == nil {
+		return false
+	}
+	return cf.acceptName(cs.Name)
+}
+
+func (cf containerFilter) acceptName(name string) bool {
+	if containsName(cf.exclude, name) {
+		return false
+	}
+	if len(cf.include) == 0 {
 		return true
 	}
-	for _, name := range cf {
-		if name == cs.Name {
+	return containsName(cf.include, name)
+}
+
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
 			return true
 		}
 	}
@@ -38,7 +73,14 @@ func sourcesForPod(filter ContainerFilter, pod *v1.Pod) (nsname.NSName, map[even
 
 	for _, cstatus := range pod.Status.ContainerStatuses {
 		if filter.Accept(cstatus) {
-			source := eventSource{id, cstatus.Name, pod.Spec.NodeName}
+			source := eventSource{id, cstatus.Name, pod.Spec.NodeName, false}
+			sources[source] = true
+		}
+	}
+
+	for _, cstatus := range pod.Status.InitContainerStatuses {
+		if filter.AcceptInit(cstatus) {
+			source := eventSource{id, cstatus.Name, pod.Spec.NodeName, true}
 			sources[source] = true
 		}
 	}
@@ -0,0 +1,40 @@
+package kail
+
+import "sync/atomic"
+
+// Metrics receives measurements from datastores and log controllers.  It
+// is small enough to adapt to Prometheus, or any other metrics library,
+// without kail depending on that library.
+type Metrics interface {
+	// PodsWatched is called with the number of pods in the matched set
+	// whenever it changes.
+	PodsWatched(count int)
+
+	// ControllersActive is called with the number of ready controllers
+	// whenever one becomes ready or done.
+	ControllersActive(count int)
+
+	// WatchError is called when a log stream fails.
+	WatchError()
+
+	// Reconnect is called when a log stream is reopened.
+	Reconnect()
+}
+
+// statsMetrics counts reconnects for the datastore's Stats.
+type statsMetrics struct {
+	Metrics
+	ds *datastore
+}
+
+func (m *statsMetrics) Reconnect() {
+	atomic.AddUint64(&m.ds.reconnects, 1)
+	m.Metrics.Reconnect()
+}
+
+type nullMetrics struct{}
+
+func (nullMetrics) PodsWatched(int)       {}
+func (nullMetrics) ControllersActive(int) {}
+func (nullMetrics) WatchError()           {}
+func (nullMetrics) Reconnect()            {}
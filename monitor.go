@@ -1,13 +1,16 @@
 package kail
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"fmt"
 	"io"
+	"math/rand"
 	"time"
 
 	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
 	"k8s.io/client-go/kubernetes"
 	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
@@ -18,6 +21,10 @@ import (
 
 const (
 	logBufsiz = 1024
+
+	// maxLineLen bounds the memory held for a single log line.  Longer
+	// lines are delivered in pieces.
+	maxLineLen = 64 * 1024
 )
 
 var (
@@ -25,7 +32,45 @@ var (
 )
 
 type monitorConfig struct {
-	since time.Duration
+	since      time.Duration
+	tail       int64
+	timestamps bool
+	previous   bool
+	backoff    reconnectBackoff
+	clock      clock.Clock
+	streams    *streamLimiter
+
+	// delay is how long to wait before first opening the stream.
+	delay time.Duration
+
+	// init streams an init container only until it exits.
+	init bool
+}
+
+// reconnectBackoff bounds the delays before reopening a log stream.
+type reconnectBackoff struct {
+	initial time.Duration
+	max     time.Duration
+}
+
+func newReconnectBackoff(initial, max time.Duration) reconnectBackoff {
+	return reconnectBackoff{initial, max}
+}
+
+// delay returns a random delay before the nth consecutive reopen, starting
+// from 1.  The bound doubles from initial with each reopen, up to max.
+func (b reconnectBackoff) delay(n int) time.Duration {
+	if b.initial <= 0 {
+		return 0
+	}
+	limit := b.initial
+	for i := 1; i < n && (b.max <= 0 || limit < b.max); i++ {
+		limit *= 2
+	}
+	if b.max > 0 && limit > b.max {
+		limit = b.max
+	}
+	return time.Duration(rand.Int63n(int64(limit)) + 1)
 }
 
 type monitor interface {
@@ -42,9 +87,11 @@ func newMonitor(c *controller, source EventSource, config monitorConfig) monitor
 
 	m := &_monitor{
 		rc:      c.rc,
+		cs:      c.cs,
 		source:  source,
 		config:  config,
 		eventch: c.eventch,
+		metrics: c.metrics,
 		log:     log,
 		lc:      lc,
 		ctx:     c.ctx,
@@ -57,9 +104,11 @@ func newMonitor(c *controller, source EventSource, config monitorConfig) monitor
 
 type _monitor struct {
 	rc      *rest.Config
+	cs      kubernetes.Interface
 	source  EventSource
 	config  monitorConfig
 	eventch chan<- Event
+	metrics Metrics
 	log     logutil.Log
 	lc      lifecycle.Lifecycle
 	ctx     context.Context
@@ -97,7 +146,12 @@ func (m *_monitor) run() {
 	<-donech
 }
 
+// makeClient creates a client for the monitor's own use from rc, or shares
+// cs if there is no rc.
 func (m *_monitor) makeClient(ctx context.Context) (corev1.CoreV1Interface, error) {
+	if m.rc == nil {
+		return m.cs.CoreV1(), nil
+	}
 	cs, err := kubernetes.NewForConfig(m.rc)
 	if err != nil {
 		return nil, err
@@ -113,40 +167,105 @@ func (m *_monitor) mainloop(
 	// todo: backoff handled by k8 client?
 
 	sinceSecs := int64(m.config.since / time.Second)
+	if sinceSecs < 1 {
+		sinceSecs = 1
+	}
 	since := &sinceSecs
 
-	m.log.Debugf("displaying logs since %v seconds", sinceSecs)
+	// the tail setting, if any, replaces since for the initial stream.
+	var tail *int64
+	switch {
+	case m.config.tail > 0:
+		tail = &m.config.tail
+		since = nil
+	case m.config.tail < 0:
+		since = nil
+	}
+
+	if !m.sleep(ctx, m.config.delay) {
+		m.lc.ShutdownAsync(nil)
+		return
+	}
+
+	if m.config.previous {
+		err := m.readloop(ctx, client, &v1.PodLogOptions{
+			Container:  m.source.Container(),
+			Previous:   true,
+			Timestamps: m.config.timestamps,
+		})
+		if err != nil && err != io.EOF && ctx.Err() == nil {
+			m.log.ErrWarn(err, "reading previous logs")
+		}
+	}
+
+	m.log.Debugf("displaying logs since %v seconds (tail: %v)", sinceSecs, m.config.tail)
 
 	for i := 0; ctx.Err() == nil; i++ {
 
 		m.log.Debugf("readloop count: %v", i)
 
-		err := m.readloop(ctx, client, since)
+		if i > 0 {
+			if !m.sleep(ctx, m.config.backoff.delay(i)) {
+				m.lc.ShutdownAsync(nil)
+				return
+			}
+			m.metrics.Reconnect()
+		}
+
+		err := m.readloop(ctx, client, &v1.PodLogOptions{
+			Container:    m.source.Container(),
+			Follow:       true,
+			SinceSeconds: since,
+			TailLines:    tail,
+			Timestamps:   m.config.timestamps,
+		})
 		switch {
+		case (err == io.EOF || err == nil) && m.config.init:
+			m.log.Debugf("init container done")
+			m.lc.ShutdownAsync(nil)
+			return
 		case err == io.EOF:
 		case err == nil:
 		case ctx.Err() != nil:
 			m.lc.ShutdownAsync(nil)
 			return
 		default:
+			m.metrics.WatchError()
 			m.log.ErrWarn(err, "streaming done")
 			m.lc.ShutdownAsync(err)
 			return
 		}
 		sinceSecs = 1
+		since = &sinceSecs
+		tail = nil
+	}
+}
+
+// sleep waits for d, returning false if ctx is done first.
+func (m *_monitor) sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	m.log.Debugf("waiting %v before opening stream", d)
+	t := m.config.clock.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C():
+		return true
+	case <-ctx.Done():
+		return false
 	}
 }
 
 func (m *_monitor) readloop(
-	ctx context.Context, client corev1.CoreV1Interface, since *int64) error {
+	ctx context.Context, client corev1.CoreV1Interface, opts *v1.PodLogOptions) error {
 
 	defer m.log.Un(m.log.Trace("readloop"))
 
-	opts := &v1.PodLogOptions{
-		Container:    m.source.Container(),
-		Follow:       true,
-		SinceSeconds: since,
+	if !m.config.streams.acquire(ctx) {
+		return ctx.Err()
 	}
+	defer m.config.streams.release()
 
 	req := client.
 		Pods(m.source.Namespace()).
@@ -160,24 +279,20 @@ func (m *_monitor) readloop(
 
 	defer stream.Close()
 
-	logbuf := make([]byte, logBufsiz)
+	reader := bufio.NewReaderSize(stream, logBufsiz)
 	for ctx.Err() == nil {
-		nread, err := stream.Read(logbuf)
+		log, err := readLine(reader)
 
 		switch {
-		case err == io.EOF:
-			return err
 		case ctx.Err() != nil:
 			return ctx.Err()
-		case err != nil:
+		case err == io.EOF && len(log) == 0:
+			return err
+		case err != nil && err != io.EOF:
 			return m.log.Err(err, "error while reading logs")
-		case nread == 0:
-			return io.EOF
 		}
 
-		log := logbuf[0:nread]
-
-		if bytes.Compare(canaryLog, log) == 0 {
+		if bytes.Equal(canaryLog, log) {
 			continue
 		}
 
@@ -186,8 +301,28 @@ func (m *_monitor) readloop(
 		select {
 		case m.eventch <- event:
 		default:
-			m.log.Warnf("event buffer full. dropping logs %v", nread)
+			m.log.Warnf("event buffer full. dropping logs %v", len(log))
+		}
+
+		if err == io.EOF {
+			return err
 		}
 	}
 	return nil
 }
+
+// readLine is reader.ReadBytes('\n'), but returns lines longer than
+// maxLineLen in pieces.
+func readLine(reader *bufio.Reader) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		line = append(line, chunk...)
+		switch {
+		case err != bufio.ErrBufferFull:
+			return line, err
+		case len(line) >= maxLineLen:
+			return line, nil
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package kail
+
+import (
+	"bytes"
+	"sort"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+const (
+	minOrderInterval = 10 * time.Millisecond
+)
+
+// orderEvents buffers events read from inch for window and writes them to
+// outch sorted by the kubelet timestamp that prefixes each line.  Events
+// without a timestamp are ordered by their arrival time.
+//
+// Each event is delayed by between window and twice window, as measured by
+// clk; larger windows tolerate more skew between sources at the cost of
+// latency.  When inch is closed, pending events are written immediately and
+// outch is closed.
+func orderEvents(clk clock.Clock, window time.Duration, inch <-chan Event, outch chan<- Event) {
+	defer close(outch)
+
+	interval := window / 2
+	if interval < minOrderInterval {
+		interval = minOrderInterval
+	}
+
+	timer := clk.NewTimer(interval)
+	defer timer.Stop()
+
+	var pending []orderedEvent
+
+	for {
+		select {
+		case ev, ok := <-inch:
+			if !ok {
+				sort.SliceStable(pending, func(a, b int) bool {
+					return pending[a].key.Before(pending[b].key)
+				})
+				for _, p := range pending {
+					outch <- p.event
+				}
+				return
+			}
+			now := clk.Now()
+			pending = append(pending, orderedEvent{ev, eventTime(ev, now), now})
+
+		case now := <-timer.C():
+			timer.Reset(interval)
+
+			sort.SliceStable(pending, func(a, b int) bool {
+				return pending[a].key.Before(pending[b].key)
+			})
+
+			deadline := now.Add(-window)
+
+			i := 0
+			for ; i < len(pending) && !pending[i].arrived.After(deadline); i++ {
+				outch <- pending[i].event
+			}
+			pending = pending[i:]
+		}
+	}
+}
+
+type orderedEvent struct {
+	event   Event
+	key     time.Time
+	arrived time.Time
+}
+
+// eventTime parses the RFC3339Nano timestamp that the kubelet prefixes to
+// log lines, returning fallback if there is none.
+func eventTime(ev Event, fallback time.Time) time.Time {
+	log := ev.Log()
+	idx := bytes.IndexByte(log, ' ')
+	if idx <= 0 {
+		return fallback
+	}
+	t, err := time.Parse(time.RFC3339Nano, string(log[:idx]))
+	if err != nil {
+		return fallback
+	}
+	return t
+}
@@ -0,0 +1,498 @@
+package kail
+
+import (
+	"net"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/boz/kcache/filter"
+	"github.com/boz/kcache/nsname"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+// idFilter accepts objects whose namespace and name are in the set.  Unlike
+// filter.NSName, an empty set accepts nothing.
+type idFilter map[nsname.NSName]bool
+
+func newIDFilter(ids []nsname.NSName) idFilter {
+	f := make(idFilter, len(ids))
+	for _, id := range ids {
+		f[id] = true
+	}
+	return f
+}
+
+func (f idFilter) Accept(obj metav1.Object) bool {
+	return f[nsname.ForObject(obj)]
+}
+
+func (f idFilter) Equals(other filter.Filter) bool {
+	o, ok := other.(idFilter)
+	return ok && equalIDSets(f, o)
+}
+
+// ownerFilter accepts objects with an owner of the given kind whose
+// namespace and name are in the set.
+type ownerFilter struct {
+	kind   string
+	owners map[nsname.NSName]bool
+}
+
+func (f ownerFilter) Accept(obj metav1.Object) bool {
+	for _, ref := range obj.GetOwnerReferences() {
+		if ref.Kind == f.kind && f.owners[nsname.New(obj.GetNamespace(), ref.Name)] {
+			return true
+		}
+	}
+	return false
+}
+
+func (f ownerFilter) Equals(other filter.Filter) bool {
+	o, ok := other.(ownerFilter)
+	return ok && o.kind == f.kind && equalIDSets(f.owners, o.owners)
+}
+
+// containerStateFilter accepts pods with a container that is waiting or
+// terminated for one of the given reasons, such as CrashLoopBackOff.
+type containerStateFilter []string
+
+func (f containerStateFilter) Accept(obj metav1.Object) bool {
+	pod, ok := obj.(*v1.Pod)
+	if !ok {
+		return false
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		state := status.State
+		if state.Waiting != nil && containsName(f, state.Waiting.Reason) {
+			return true
+		}
+		if state.Terminated != nil && containsName(f, state.Terminated.Reason) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f containerStateFilter) Equals(other filter.Filter) bool {
+	o, ok := other.(containerStateFilter)
+	return ok && equalEach(len(f), len(o), func(i int) bool { return f[i] == o[i] })
+}
+
+// restartFilter accepts pods whose restart count is at least min.  The
+// count is the sum over all containers, or the highest single container's
+// count if perContainer is set.
+type restartFilter struct {
+	min          int32
+	perContainer bool
+}
+
+func (f restartFilter) Accept(obj metav1.Object) bool {
+	pod, ok := obj.(*v1.Pod)
+	if !ok {
+		return false
+	}
+	var count int32
+	for _, status := range pod.Status.ContainerStatuses {
+		if !f.perContainer {
+			count += status.RestartCount
+		} else if status.RestartCount > count {
+			count = status.RestartCount
+		}
+	}
+	return count >= f.min
+}
+
+func (f restartFilter) Equals(other filter.Filter) bool {
+	o, ok := other.(restartFilter)
+	return ok && o == f
+}
+
+// readyContainersFilter accepts pods with at least the given number of
+// ready containers.  Init and ephemeral containers are not counted.
+type readyContainersFilter int
+
+func (f readyContainersFilter) Accept(obj metav1.Object) bool {
+	pod, ok := obj.(*v1.Pod)
+	if !ok {
+		return false
+	}
+	ready := 0
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Ready {
+			ready++
+		}
+	}
+	return ready >= int(f)
+}
+
+func (f readyContainersFilter) Equals(other filter.Filter) bool {
+	o, ok := other.(readyContainersFilter)
+	return ok && o == f
+}
+
+// conditionFilter accepts pods reporting a condition of the given type and
+// status.
+type conditionFilter struct {
+	condType v1.PodConditionType
+	status   v1.ConditionStatus
+}
+
+func (f conditionFilter) Accept(obj metav1.Object) bool {
+	pod, ok := obj.(*v1.Pod)
+	if !ok {
+		return false
+	}
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == f.condType {
+			return cond.Status == f.status
+		}
+	}
+	return false
+}
+
+func (f conditionFilter) Equals(other filter.Filter) bool {
+	o, ok := other.(conditionFilter)
+	return ok && o == f
+}
+
+// ageFilter accepts pods created within newerThan and at least olderThan
+// ago.  Zero durations are ignored.  Age is computed against the clock
+// time whenever a pod is evaluated, which only happens when the pod
+// changes: a pod that ages out remains in the set until its next update.
+type ageFilter struct {
+	newerThan time.Duration
+	olderThan time.Duration
+	clock     clock.Clock
+}
+
+func (f ageFilter) Accept(obj metav1.Object) bool {
+	created := obj.GetCreationTimestamp()
+	age := f.clock.Since(created.Time)
+	if f.newerThan > 0 && age > f.newerThan {
+		return false
+	}
+	if f.olderThan > 0 && age < f.olderThan {
+		return false
+	}
+	return true
+}
+
+func (f ageFilter) Equals(other filter.Filter) bool {
+	o, ok := other.(ageFilter)
+	return ok && o.newerThan == f.newerThan && o.olderThan == f.olderThan
+}
+
+// hostNetworkFilter accepts pods whose spec.hostNetwork is equal to it.
+type hostNetworkFilter bool
+
+func (f hostNetworkFilter) Accept(obj metav1.Object) bool {
+	pod, ok := obj.(*v1.Pod)
+	if !ok {
+		return false
+	}
+	return pod.Spec.HostNetwork == bool(f)
+}
+
+func (f hostNetworkFilter) Equals(other filter.Filter) bool {
+	o, ok := other.(hostNetworkFilter)
+	return ok && o == f
+}
+
+// terminatingFilter accepts objects that are being deleted, if set, or
+// that are not otherwise.
+type terminatingFilter bool
+
+func (f terminatingFilter) Accept(obj metav1.Object) bool {
+	return (obj.GetDeletionTimestamp() != nil) == bool(f)
+}
+
+func (f terminatingFilter) Equals(other filter.Filter) bool {
+	o, ok := other.(terminatingFilter)
+	return ok && o == f
+}
+
+// phaseFilter accepts pods in any of the given phases.
+type phaseFilter []v1.PodPhase
+
+func (f phaseFilter) Accept(obj metav1.Object) bool {
+	pod, ok := obj.(*v1.Pod)
+	if !ok {
+		return false
+	}
+	for _, phase := range f {
+		if pod.Status.Phase == phase {
+			return true
+		}
+	}
+	return false
+}
+
+func (f phaseFilter) Equals(other filter.Filter) bool {
+	o, ok := other.(phaseFilter)
+	return ok && equalEach(len(f), len(o), func(i int) bool { return f[i] == o[i] })
+}
+
+// qosClassFilter accepts pods whose status reports any of the given QoS
+// classes.
+type qosClassFilter []v1.PodQOSClass
+
+func (f qosClassFilter) Accept(obj metav1.Object) bool {
+	pod, ok := obj.(*v1.Pod)
+	if !ok {
+		return false
+	}
+	for _, class := range f {
+		if pod.Status.QOSClass == class {
+			return true
+		}
+	}
+	return false
+}
+
+func (f qosClassFilter) Equals(other filter.Filter) bool {
+	o, ok := other.(qosClassFilter)
+	return ok && equalEach(len(f), len(o), func(i int) bool { return f[i] == o[i] })
+}
+
+// priorityClassFilter accepts pods whose spec.priorityClassName is any of
+// the given names.
+type priorityClassFilter []string
+
+func (f priorityClassFilter) Accept(obj metav1.Object) bool {
+	pod, ok := obj.(*v1.Pod)
+	if !ok {
+		return false
+	}
+	return containsName(f, pod.Spec.PriorityClassName)
+}
+
+func (f priorityClassFilter) Equals(other filter.Filter) bool {
+	o, ok := other.(priorityClassFilter)
+	return ok && equalEach(len(f), len(o), func(i int) bool { return f[i] == o[i] })
+}
+
+// podCIDRFilter accepts pods whose status.podIP is within any of the given
+// networks.  Pods without an IP are not accepted.
+type podCIDRFilter []*net.IPNet
+
+func (f podCIDRFilter) Accept(obj metav1.Object) bool {
+	pod, ok := obj.(*v1.Pod)
+	if !ok {
+		return false
+	}
+	ip := net.ParseIP(pod.Status.PodIP)
+	if ip == nil {
+		return false
+	}
+	for _, ipnet := range f {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f podCIDRFilter) Equals(other filter.Filter) bool {
+	o, ok := other.(podCIDRFilter)
+	return ok && equalEach(len(f), len(o), func(i int) bool { return f[i].String() == o[i].String() })
+}
+
+// hostnameFilter accepts pods whose hostname, or hostname qualified by
+// subdomain, is any of the given names.  The hostname defaults to the pod
+// name.
+type hostnameFilter []string
+
+func (f hostnameFilter) Accept(obj metav1.Object) bool {
+	pod, ok := obj.(*v1.Pod)
+	if !ok {
+		return false
+	}
+	hostname := pod.Spec.Hostname
+	if hostname == "" {
+		hostname = pod.GetName()
+	}
+	if containsName(f, hostname) {
+		return true
+	}
+	return pod.Spec.Subdomain != "" &&
+		containsName(f, hostname+"."+pod.Spec.Subdomain)
+}
+
+func (f hostnameFilter) Equals(other filter.Filter) bool {
+	o, ok := other.(hostnameFilter)
+	return ok && equalEach(len(f), len(o), func(i int) bool { return f[i] == o[i] })
+}
+
+// nodeSelectorKeyFilter accepts pods whose spec.nodeSelector has any of the
+// given keys.
+type nodeSelectorKeyFilter []string
+
+func (f nodeSelectorKeyFilter) Accept(obj metav1.Object) bool {
+	pod, ok := obj.(*v1.Pod)
+	if !ok {
+		return false
+	}
+	for _, key := range f {
+		if _, ok := pod.Spec.NodeSelector[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (f nodeSelectorKeyFilter) Equals(other filter.Filter) bool {
+	o, ok := other.(nodeSelectorKeyFilter)
+	return ok && equalEach(len(f), len(o), func(i int) bool { return f[i] == o[i] })
+}
+
+// imageIDFilter accepts pods with a container whose status.imageID is any
+// of the given IDs, or ends with one following an "@" or "://".
+type imageIDFilter []string
+
+func (f imageIDFilter) Accept(obj metav1.Object) bool {
+	pod, ok := obj.(*v1.Pod)
+	if !ok {
+		return false
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.ImageID == "" {
+			continue
+		}
+		for _, id := range f {
+			if matchImageID(status.ImageID, id) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (f imageIDFilter) Equals(other filter.Filter) bool {
+	o, ok := other.(imageIDFilter)
+	return ok && equalEach(len(f), len(o), func(i int) bool { return f[i] == o[i] })
+}
+
+// matchImageID reports whether id names the reported imageID, either whole
+// or as the part following its scheme or repository.
+func matchImageID(imageID, id string) bool {
+	if imageID == id {
+		return true
+	}
+	if id == "" || !strings.HasSuffix(imageID, id) {
+		return false
+	}
+	prefix := imageID[:len(imageID)-len(id)]
+	return strings.HasSuffix(prefix, "@") || strings.HasSuffix(prefix, "://")
+}
+
+// nameGlobFilter accepts objects whose name matches any of the patterns,
+// using path.Match syntax.  Matching is case-sensitive.
+type nameGlobFilter []string
+
+func (f nameGlobFilter) Accept(obj metav1.Object) bool {
+	for _, pattern := range f {
+		if ok, _ := path.Match(pattern, obj.GetName()); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (f nameGlobFilter) Equals(other filter.Filter) bool {
+	o, ok := other.(nameGlobFilter)
+	return ok && equalEach(len(f), len(o), func(i int) bool { return f[i] == o[i] })
+}
+
+// nameRegexFilter accepts objects whose name matches any of the
+// expressions.
+type nameRegexFilter []*regexp.Regexp
+
+func (f nameRegexFilter) Accept(obj metav1.Object) bool {
+	for _, expr := range f {
+		if expr.MatchString(obj.GetName()) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f nameRegexFilter) Equals(other filter.Filter) bool {
+	o, ok := other.(nameRegexFilter)
+	return ok && equalEach(len(f), len(o), func(i int) bool { return f[i].String() == o[i].String() })
+}
+
+// serviceTypeFilter accepts services of any of the given types.
+type serviceTypeFilter []v1.ServiceType
+
+func (f serviceTypeFilter) Accept(obj metav1.Object) bool {
+	svc, ok := obj.(*v1.Service)
+	if !ok {
+		return false
+	}
+	for _, t := range f {
+		if svc.Spec.Type == t {
+			return true
+		}
+	}
+	return false
+}
+
+func (f serviceTypeFilter) Equals(other filter.Filter) bool {
+	o, ok := other.(serviceTypeFilter)
+	return ok && equalEach(len(f), len(o), func(i int) bool { return f[i] == o[i] })
+}
+
+// nodeReadyFilter accepts nodes whose Ready condition is true, or, if
+// false, nodes whose Ready condition is false, unknown or missing.
+type nodeReadyFilter bool
+
+func (f nodeReadyFilter) Accept(obj metav1.Object) bool {
+	node, ok := obj.(*v1.Node)
+	if !ok {
+		return false
+	}
+	ready := false
+	for _, cond := range node.Status.Conditions {
+		if cond.Type == v1.NodeReady {
+			ready = cond.Status == v1.ConditionTrue
+			break
+		}
+	}
+	return ready == bool(f)
+}
+
+func (f nodeReadyFilter) Equals(other filter.Filter) bool {
+	o, ok := other.(nodeReadyFilter)
+	return ok && o == f
+}
+
+// equalEach reports whether two sequences of lengths n and m are equal,
+// as judged by eq for the elements at each index.  It implements Equals
+// for filters over ordered lists of values.
+func equalEach(n, m int, eq func(i int) bool) bool {
+	if n != m {
+		return false
+	}
+	for i := 0; i < n; i++ {
+		if !eq(i) {
+			return false
+		}
+	}
+	return true
+}
+
+func equalIDSets(a, b map[nsname.NSName]bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for id := range a {
+		if !b[id] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,168 @@
+package kail
+
+import (
+	"testing"
+	"time"
+
+	"github.com/boz/kcache/filter"
+	"github.com/boz/kcache/nsname"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+func TestFilterEquals(t *testing.T) {
+	tests := []struct {
+		name  string
+		a, b  filter.Filter
+		equal bool
+	}{
+		{"ids", newIDFilter([]nsname.NSName{{Namespace: "a", Name: "b"}}),
+			newIDFilter([]nsname.NSName{{Namespace: "a", Name: "b"}}), true},
+		{"ids differ", newIDFilter([]nsname.NSName{{Namespace: "a", Name: "b"}}),
+			newIDFilter([]nsname.NSName{{Namespace: "a", Name: "c"}}), false},
+		{"phases", phaseFilter{v1.PodRunning}, phaseFilter{v1.PodRunning}, true},
+		{"phases differ", phaseFilter{v1.PodRunning}, phaseFilter{v1.PodPending}, false},
+		{"phases length", phaseFilter{v1.PodRunning}, phaseFilter{v1.PodRunning, v1.PodPending}, false},
+		{"names", hostnameFilter{"a", "b"}, hostnameFilter{"a", "b"}, true},
+		{"names order", hostnameFilter{"a", "b"}, hostnameFilter{"b", "a"}, false},
+		{"types differ", hostnameFilter{"a"}, nodeSelectorKeyFilter{"a"}, false},
+		{"service types", serviceTypeFilter{v1.ServiceTypeNodePort}, serviceTypeFilter{v1.ServiceTypeNodePort}, true},
+		{"empty", imageIDFilter{}, imageIDFilter(nil), true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := test.a.(filter.ComparableFilter).Equals(test.b)
+			if got != test.equal {
+				t.Errorf("equals: got %v, want %v", got, test.equal)
+			}
+		})
+	}
+}
+
+func TestAgeFilter(t *testing.T) {
+	now := time.Date(2017, time.June, 1, 12, 0, 0, 0, time.UTC)
+	createdAgo := func(d time.Duration) *v1.Pod {
+		pod := testPod("default", "web", nil)
+		pod.CreationTimestamp = metav1.NewTime(now.Add(-d))
+		return pod
+	}
+
+	tests := []struct {
+		name   string
+		pod    *v1.Pod
+		step   time.Duration
+		accept bool
+	}{
+		{"too new", createdAgo(5 * time.Minute), 0, false},
+		{"within window", createdAgo(30 * time.Minute), 0, true},
+		{"too old", createdAgo(2 * time.Hour), 0, false},
+		{"aged into window", createdAgo(5 * time.Minute), 10 * time.Minute, true},
+		{"aged out of window", createdAgo(30 * time.Minute), time.Hour, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clk := clock.NewFakeClock(now)
+			b := NewDSBuilder().
+				WithClock(clk).
+				WithNewerThan(time.Hour).
+				WithOlderThan(10 * time.Minute).(*dsBuilder)
+
+			f := b.podFilter()
+			clk.Step(test.step)
+
+			if got := f.Accept(test.pod); got != test.accept {
+				t.Errorf("accept: got %v, want %v", got, test.accept)
+			}
+		})
+	}
+}
+
+func TestHostNetworkFilter(t *testing.T) {
+	withHostNetwork := func(enabled bool) *v1.Pod {
+		pod := testPod("default", "web", nil)
+		pod.Spec.HostNetwork = enabled
+		return pod
+	}
+
+	tests := []struct {
+		name   string
+		filter hostNetworkFilter
+		pod    *v1.Pod
+		accept bool
+	}{
+		{"host network wanted", true, withHostNetwork(true), true},
+		{"host network unwanted", false, withHostNetwork(true), false},
+		{"pod network wanted", false, withHostNetwork(false), true},
+		{"pod network unwanted", true, withHostNetwork(false), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.filter.Accept(test.pod); got != test.accept {
+				t.Errorf("accept: got %v, want %v", got, test.accept)
+			}
+		})
+	}
+}
+
+func TestQoSClassFilter(t *testing.T) {
+	withClass := func(class v1.PodQOSClass) *v1.Pod {
+		pod := testPod("default", "web", nil)
+		pod.Status.QOSClass = class
+		return pod
+	}
+
+	tests := []struct {
+		name   string
+		filter qosClassFilter
+		pod    *v1.Pod
+		accept bool
+	}{
+		{"guaranteed", qosClassFilter{v1.PodQOSGuaranteed}, withClass(v1.PodQOSGuaranteed), true},
+		{"burstable", qosClassFilter{v1.PodQOSBurstable}, withClass(v1.PodQOSBurstable), true},
+		{"best effort", qosClassFilter{v1.PodQOSBestEffort}, withClass(v1.PodQOSBestEffort), true},
+		{"other class", qosClassFilter{v1.PodQOSBestEffort}, withClass(v1.PodQOSGuaranteed), false},
+		{"any of", qosClassFilter{v1.PodQOSBurstable, v1.PodQOSBestEffort}, withClass(v1.PodQOSBestEffort), true},
+		{"none of", qosClassFilter{v1.PodQOSBurstable, v1.PodQOSBestEffort}, withClass(v1.PodQOSGuaranteed), false},
+		{"unset", qosClassFilter{v1.PodQOSBestEffort}, withClass(""), false},
+		{"unset any of", qosClassFilter{v1.PodQOSGuaranteed, v1.PodQOSBurstable, v1.PodQOSBestEffort}, withClass(""), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.filter.Accept(test.pod); got != test.accept {
+				t.Errorf("accept: got %v, want %v", got, test.accept)
+			}
+		})
+	}
+}
+
+func TestMatchImageID(t *testing.T) {
+	const reported = "docker-pullable://repo@sha256:abcdef"
+
+	tests := []struct {
+		name  string
+		id    string
+		match bool
+	}{
+		{"exact", reported, true},
+		{"repository and digest", "repo@sha256:abcdef", true},
+		{"digest", "sha256:abcdef", true},
+		{"partial digest", "abcdef", false},
+		{"digest suffix", "cdef", false},
+		{"partial repository", "po@sha256:abcdef", false},
+		{"empty", "", false},
+		{"other digest", "sha256:012345", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := matchImageID(reported, test.id); got != test.match {
+				t.Errorf("match %q: got %v, want %v", test.id, got, test.match)
+			}
+		})
+	}
+}
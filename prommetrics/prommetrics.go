@@ -0,0 +1,82 @@
+// Package prommetrics reports kail datastore and log streaming
+// measurements to Prometheus.  It is kept apart from package kail so that
+// only programs that use it depend on the Prometheus client.
+package prommetrics
+
+import (
+	"github.com/boz/kail"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "kail"
+
+type metrics struct {
+	podsWatched       prometheus.Gauge
+	controllersActive prometheus.Gauge
+	watchErrors       prometheus.Counter
+	reconnects        prometheus.Counter
+}
+
+// New returns kail.Metrics for WithMetrics that updates the following
+// collectors, registering them with reg:
+//
+//	kail_pods_watched        gauge of pods in the matched set
+//	kail_controllers_active  gauge of ready controllers
+//	kail_watch_errors_total  counter of failed log streams
+//	kail_reconnects_total    counter of reopened log streams
+//
+// The collectors can only be registered once with a registerer, so a
+// single value should be shared by the datastores reporting to it.
+func New(reg prometheus.Registerer) (kail.Metrics, error) {
+	m := &metrics{
+		podsWatched: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "pods_watched",
+			Help:      "Number of pods matched by the datastore.",
+		}),
+		controllersActive: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "controllers_active",
+			Help:      "Number of ready datastore controllers.",
+		}),
+		watchErrors: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "watch_errors_total",
+			Help:      "Number of log streams that failed.",
+		}),
+		reconnects: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "reconnects_total",
+			Help:      "Number of log streams reopened.",
+		}),
+	}
+
+	collectors := []prometheus.Collector{
+		m.podsWatched,
+		m.controllersActive,
+		m.watchErrors,
+		m.reconnects,
+	}
+	for _, c := range collectors {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (m *metrics) PodsWatched(count int) {
+	m.podsWatched.Set(float64(count))
+}
+
+func (m *metrics) ControllersActive(count int) {
+	m.controllersActive.Set(float64(count))
+}
+
+func (m *metrics) WatchError() {
+	m.watchErrors.Inc()
+}
+
+func (m *metrics) Reconnect() {
+	m.reconnects.Inc()
+}
@@ -0,0 +1,105 @@
+package prommetrics
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/boz/kail"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const testTimeout = 5 * time.Second
+
+func testPod(ns, name string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: name, ResourceVersion: "1"},
+		Status:     v1.PodStatus{Phase: v1.PodRunning},
+	}
+}
+
+// gather scrapes reg, returning the value of each gauge and counter by
+// name.
+func gather(t *testing.T, reg *prometheus.Registry) map[string]float64 {
+	t.Helper()
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("gather: %v", err)
+	}
+
+	values := make(map[string]float64)
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			switch {
+			case m.GetGauge() != nil:
+				values[family.GetName()] = m.GetGauge().GetValue()
+			case m.GetCounter() != nil:
+				values[family.GetName()] = m.GetCounter().GetValue()
+			}
+		}
+	}
+	return values
+}
+
+func TestMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	m, err := New(reg)
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	if _, err := New(reg); err == nil {
+		t.Errorf("new: registered twice without error")
+	}
+
+	cs := fake.NewSimpleClientset(
+		testPod("default", "web"),
+		testPod("default", "api"),
+		testPod("kube-system", "dns"),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	ds, err := kail.NewDSBuilder().WithNamespace("default").WithMetrics(m).Create(ctx, cs)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	defer func() {
+		ds.Close()
+		<-ds.Done()
+	}()
+	if err := ds.ReadyContext(ctx); err != nil {
+		t.Fatalf("ready: %v", err)
+	}
+
+	m.WatchError()
+	m.Reconnect()
+	m.Reconnect()
+
+	want := map[string]float64{
+		"kail_pods_watched":       2,
+		"kail_watch_errors_total": 1,
+		"kail_reconnects_total":   2,
+	}
+
+	deadline := time.Now().Add(testTimeout)
+	for {
+		got := gather(t, reg)
+
+		ok := got["kail_controllers_active"] > 0
+		for name, value := range want {
+			ok = ok && got[name] == value
+		}
+		if ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("metrics: got %v, want %v and active controllers", got, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
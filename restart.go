@@ -0,0 +1,66 @@
+package kail
+
+import (
+	"github.com/boz/kcache/nsname"
+	"k8s.io/api/core/v1"
+)
+
+// restartTracker records container restart counts and ids as pod updates
+// arrive so that restarted containers can have their log streams reopened.
+type restartTracker struct {
+	states  map[eventSource]containerState
+	pending map[eventSource]bool
+}
+
+type containerState struct {
+	restarts int32
+	id       string
+}
+
+func newRestartTracker() *restartTracker {
+	return &restartTracker{
+		states:  make(map[eventSource]containerState),
+		pending: make(map[eventSource]bool),
+	}
+}
+
+// update records the state of the pod's containers, marking any container
+// whose restart count increased or whose id changed as restarted.
+func (t *restartTracker) update(pod *v1.Pod) {
+	id := nsname.ForObject(pod)
+	for _, cstatus := range pod.Status.ContainerStatuses {
+		source := eventSource{id, cstatus.Name, pod.Spec.NodeName, false}
+		current := containerState{cstatus.RestartCount, cstatus.ContainerID}
+
+		if prev, ok := t.states[source]; ok && restarted(prev, current) {
+			t.pending[source] = true
+		}
+		t.states[source] = current
+	}
+}
+
+// restarted reports whether the source has restarted since it was last
+// cleared.
+func (t *restartTracker) restarted(source eventSource) bool {
+	return t.pending[source]
+}
+
+func (t *restartTracker) clear(source eventSource) {
+	delete(t.pending, source)
+}
+
+func (t *restartTracker) remove(id nsname.NSName) {
+	for source := range t.states {
+		if source.id == id {
+			delete(t.states, source)
+			delete(t.pending, source)
+		}
+	}
+}
+
+func restarted(prev, current containerState) bool {
+	if current.restarts > prev.restarts {
+		return true
+	}
+	return prev.id != "" && current.id != "" && prev.id != current.id
+}
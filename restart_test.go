@@ -0,0 +1,76 @@
+package kail
+
+import (
+	"testing"
+
+	"github.com/boz/kcache/nsname"
+	"k8s.io/api/core/v1"
+)
+
+func TestRestartTracker(t *testing.T) {
+	type state struct {
+		restarts int32
+		id       string
+	}
+
+	tests := []struct {
+		name      string
+		states    []state
+		restarted bool
+	}{
+		{"first sight", []state{{0, "docker://a"}}, false},
+		{"unchanged", []state{{0, "docker://a"}, {0, "docker://a"}}, false},
+		{"restart count", []state{{0, "docker://a"}, {1, "docker://a"}}, true},
+		{"container id", []state{{0, "docker://a"}, {0, "docker://b"}}, true},
+		{"id assigned", []state{{0, ""}, {0, "docker://a"}}, false},
+		{"restart then unchanged", []state{{0, "docker://a"}, {1, "docker://b"}, {1, "docker://b"}}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tracker := newRestartTracker()
+
+			pod := testPod("default", "web", nil)
+			pod.Spec.NodeName = "node-1"
+			source := eventSource{nsname.ForObject(pod), "app", "node-1", false}
+
+			for _, s := range test.states {
+				pod.Status.ContainerStatuses = []v1.ContainerStatus{{
+					Name:         "app",
+					RestartCount: s.restarts,
+					ContainerID:  s.id,
+				}}
+				tracker.update(pod)
+			}
+
+			if got := tracker.restarted(source); got != test.restarted {
+				t.Fatalf("restarted: got %v, want %v", got, test.restarted)
+			}
+
+			tracker.clear(source)
+			if tracker.restarted(source) {
+				t.Errorf("restarted after clear")
+			}
+		})
+	}
+}
+
+func TestRestartTrackerRemove(t *testing.T) {
+	tracker := newRestartTracker()
+
+	pod := testPod("default", "web", nil)
+	id := nsname.ForObject(pod)
+	source := eventSource{id, "app", "", false}
+
+	pod.Status.ContainerStatuses = []v1.ContainerStatus{{Name: "app", ContainerID: "docker://a"}}
+	tracker.update(pod)
+
+	// a recreated pod with the same name is not a restart.
+	tracker.remove(id)
+	pod.Status.ContainerStatuses = []v1.ContainerStatus{{Name: "app", ContainerID: "docker://b"}}
+	tracker.update(pod)
+
+	if tracker.restarted(source) {
+		t.Errorf("restarted after remove")
+	}
+}
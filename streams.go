@@ -0,0 +1,68 @@
+package kail
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// streamLimiter caps the number of log streams open at once across all of
+// a datastore's controllers.  Streams over the cap wait for a slot to
+// free up; none are dropped.
+type streamLimiter struct {
+	// sem holds a token for each open stream.  It is nil when there is no
+	// cap.
+	sem chan struct{}
+
+	open   int64
+	queued int64
+}
+
+func newStreamLimiter(max int) *streamLimiter {
+	l := &streamLimiter{}
+	if max > 0 {
+		l.sem = make(chan struct{}, max)
+	}
+	return l
+}
+
+// acquire waits for a free slot, returning false if ctx is done first.
+// Each successful acquire must be followed by a release.
+func (l *streamLimiter) acquire(ctx context.Context) bool {
+	if l.sem != nil {
+		select {
+		case l.sem <- struct{}{}:
+		default:
+			atomic.AddInt64(&l.queued, 1)
+			select {
+			case l.sem <- struct{}{}:
+				atomic.AddInt64(&l.queued, -1)
+			case <-ctx.Done():
+				atomic.AddInt64(&l.queued, -1)
+				return false
+			}
+		}
+	}
+	atomic.AddInt64(&l.open, 1)
+	return true
+}
+
+func (l *streamLimiter) release() {
+	atomic.AddInt64(&l.open, -1)
+	if l.sem != nil {
+		<-l.sem
+	}
+}
+
+// counts returns the number of open and waiting streams.
+func (l *streamLimiter) counts() (open, queued int) {
+	return int(atomic.LoadInt64(&l.open)), int(atomic.LoadInt64(&l.queued))
+}
+
+// dsStreamLimiter returns the limiter shared by the controllers of ds, or a
+// new one for DS implementations other than this package's.
+func dsStreamLimiter(ds DS) *streamLimiter {
+	if ds, ok := ds.(*datastore); ok {
+		return ds.streams
+	}
+	return newStreamLimiter(ds.MaxConcurrentStreams())
+}
@@ -0,0 +1,46 @@
+package kail
+
+import "context"
+
+// Tracer starts spans around datastore creation: one for the whole of
+// Create, one for each controller it constructs and one for the wait until
+// the datastore is ready.  It is deliberately small so that an
+// OpenTelemetry trace.Tracer, or any other tracing library, can be adapted
+// to it without kail depending on that library.
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a unit of work started by a Tracer.
+type Span interface {
+	SetAttribute(key, value string)
+	RecordError(err error)
+	End()
+}
+
+type nullTracer struct{}
+
+func (nullTracer) Start(ctx context.Context, _ string) (context.Context, Span) {
+	return ctx, nullSpan{}
+}
+
+type nullSpan struct{}
+
+func (nullSpan) SetAttribute(string, string) {}
+func (nullSpan) RecordError(error)           {}
+func (nullSpan) End()                        {}
+
+// startSpan starts a span for constructing the given kind of controller.
+func startSpan(ctx context.Context, tracer Tracer, name, kind string) (context.Context, Span) {
+	ctx, span := tracer.Start(ctx, name)
+	span.SetAttribute("kail.kind", kind)
+	return ctx, span
+}
+
+// endSpan records err, if any, and ends span.
+func endSpan(span Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
@@ -17,6 +17,10 @@ type eventSource struct {
 	id        nsname.NSName
 	container string
 	node      string
+
+	// init is set for init containers, which run to completion before the
+	// regular containers start.
+	init bool
 }
 
 func (es eventSource) Namespace() string {
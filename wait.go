@@ -0,0 +1,74 @@
+package kail
+
+import (
+	"context"
+	"errors"
+
+	"github.com/boz/kcache"
+	"github.com/boz/kcache/nsname"
+	"k8s.io/api/core/v1"
+)
+
+var (
+	// ErrPodRemoved is returned by WaitPodReady when the pod leaves the
+	// matched set before becoming ready.
+	ErrPodRemoved = errors.New("pod removed before ready")
+
+	// ErrDatastoreDone is returned by WaitPodReady when the datastore is
+	// done before the pod becomes ready.
+	ErrDatastoreDone = errors.New("datastore done")
+)
+
+// WaitPodReady blocks until the pod with the given id is in the matched
+// set of ds and has the Ready condition, ctx is done, or ds is done.
+func WaitPodReady(ctx context.Context, ds DS, id nsname.NSName) error {
+	sub, err := ds.Pods().Subscribe()
+	if err != nil {
+		return err
+	}
+	defer sub.Close()
+
+	select {
+	case <-sub.Ready():
+	case <-sub.Done():
+		return ErrDatastoreDone
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if pod, err := sub.Cache().Get(id.Namespace, id.Name); err == nil && pod != nil && isPodReady(pod) {
+		return nil
+	}
+
+	for {
+		select {
+		case ev, ok := <-sub.Events():
+			if !ok {
+				return ErrDatastoreDone
+			}
+			pod := ev.Resource()
+			if nsname.ForObject(pod) != id {
+				continue
+			}
+			if ev.Type() == kcache.EventTypeDelete {
+				return ErrPodRemoved
+			}
+			if isPodReady(pod) {
+				return nil
+			}
+		case <-sub.Done():
+			return ErrDatastoreDone
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func isPodReady(pod *v1.Pod) bool {
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == v1.PodReady {
+			return cond.Status == v1.ConditionTrue
+		}
+	}
+	return false
+}